@@ -1,50 +1,291 @@
 package cache
 
 import (
+	"container/heap"
 	"sync"
 	"time"
 )
 
+// Eviction policies, deciding which entry makes room when the cache is full.
+const (
+	LRU = "lru" // Least recently used (default)
+	LFU = "lfu" // Least frequently used, the least recently used of them first
+)
+
+// Defaults used for zero Options fields.
+const (
+	DefaultTTL        = 5 * time.Minute
+	DefaultMaxEntries = 10000
+	DefaultMaxBytes   = 64 << 20
+	DefaultSweep      = time.Minute
+)
+
+// Options select and bound the cache. Zero values take the defaults.
+type Options struct {
+	Backend    string // Memory, Disk or Redis
+	TTL        time.Duration
+	MaxEntries int
+	MaxBytes   int
+	Eviction   string        // LRU or LFU
+	Sweep      time.Duration // How often expired entries are swept out
+
+	Path  string // Directory the disk backend keeps entries in
+	Redis RedisOptions
+}
+
+// Stats counts what the cache has done since it was created.
+type Stats struct {
+	Hits        int64
+	Misses      int64
+	Evictions   int64 // Entries dropped to make room
+	Expirations int64 // Entries dropped because their TTL passed
+	Errors      int64 // Failed reads and writes of a backend
+	Entries     int
+	Bytes       int
+}
+
 type CacheEntry struct {
 	Data      []byte
+	StoredAt  time.Time
 	ExpiresAt time.Time
+
+	key   string
+	hits  int
+	used  uint64 // Access sequence number, for recency
+	index int    // Position in the eviction heap
+}
+
+func (e *CacheEntry) size() int {
+	return len(e.key) + len(e.Data)
 }
 
 type Cache struct {
-	entries map[string]CacheEntry
-	mu      sync.RWMutex
+	entries map[string]*CacheEntry
+	order   evictionHeap
+	mu      sync.Mutex
 	ttl     time.Duration
+	opts    Options
+	seq     uint64
+	bytes   int
+	stats   Stats
+	now     func() time.Time
+
+	// onRemove is called with the key of every entry dropped for space or
+	// age, with the lock held
+	onRemove func(key string)
+
+	stop      chan struct{}
+	closeOnce sync.Once
+	done      sync.WaitGroup
 }
 
-func New(ttl time.Duration) *Cache {
-	return &Cache{
-		entries: make(map[string]CacheEntry),
-		ttl:     ttl,
+func New(opts Options) *Cache {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultMaxEntries
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxBytes
+	}
+	if opts.Eviction == "" {
+		opts.Eviction = LRU
+	}
+	if opts.Sweep <= 0 {
+		opts.Sweep = DefaultSweep
+	}
+
+	c := &Cache{
+		entries: make(map[string]*CacheEntry),
+		ttl:     opts.TTL,
+		opts:    opts,
+		now:     time.Now,
+		stop:    make(chan struct{}),
 	}
+	c.order.lfu = opts.Eviction == LFU
+
+	c.done.Add(1)
+	go c.janitor()
+	return c
 }
 
-func (c *Cache) Get(key string) ([]byte, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+func (c *Cache) Get(key string) ([]byte, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	entry, exists := c.entries[key]
 	if !exists {
-		return nil, false
+		c.stats.Misses++
+		return nil, time.Time{}, false
 	}
 
-	if time.Now().After(entry.ExpiresAt) {
-		return nil, false
+	if c.now().After(entry.ExpiresAt) {
+		c.drop(entry)
+		c.stats.Expirations++
+		c.stats.Misses++
+		return nil, time.Time{}, false
 	}
 
-	return entry.Data, true
+	c.stats.Hits++
+	entry.hits++
+	c.touch(entry)
+	return entry.Data, entry.StoredAt, true
 }
 
-func (c *Cache) Set(key string, data []byte) {
+func (c *Cache) Set(key string, data []byte, ttl time.Duration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.entries[key] = CacheEntry{
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
+	now := c.now()
+	c.set(key, data, now, now.Add(ttl))
+}
+
+func (c *Cache) set(key string, data []byte, storedAt, expiresAt time.Time) {
+	if old, exists := c.entries[key]; exists {
+		c.remove(old)
+	}
+
+	entry := &CacheEntry{
 		Data:      data,
-		ExpiresAt: time.Now().Add(c.ttl),
+		StoredAt:  storedAt,
+		ExpiresAt: expiresAt,
+		key:       key,
+	}
+	// An entry that could never fit would only flush everything else
+	if entry.size() > c.opts.MaxBytes {
+		return
+	}
+
+	for len(c.entries) >= c.opts.MaxEntries || c.bytes+entry.size() > c.opts.MaxBytes {
+		c.drop(c.order.entries[0])
+		c.stats.Evictions++
+	}
+
+	c.entries[key] = entry
+	c.bytes += entry.size()
+	c.seq++
+	entry.used = c.seq
+	heap.Push(&c.order, entry)
+}
+
+// Clear drops every entry. The counters are kept.
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clear()
+}
+
+func (c *Cache) clear() {
+	c.entries = make(map[string]*CacheEntry)
+	c.order.entries = nil
+	c.bytes = 0
+}
+
+// Stats returns the cache's counters and current size.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	stats.Bytes = c.bytes
+	return stats
+}
+
+// Close stops the janitor. The cache can still be used afterwards, but
+// expired entries are then only dropped when they are looked up.
+func (c *Cache) Close() error {
+	c.closeOnce.Do(func() { close(c.stop) })
+	c.done.Wait()
+	return nil
+}
+
+// janitor sweeps out expired entries until the cache is closed.
+func (c *Cache) janitor() {
+	defer c.done.Done()
+
+	ticker := time.NewTicker(c.opts.Sweep)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.sweep()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// sweep drops every expired entry.
+func (c *Cache) sweep() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for _, entry := range c.entries {
+		if now.After(entry.ExpiresAt) {
+			c.drop(entry)
+			c.stats.Expirations++
+		}
+	}
+}
+
+func (c *Cache) touch(entry *CacheEntry) {
+	c.seq++
+	entry.used = c.seq
+	heap.Fix(&c.order, entry.index)
+}
+
+func (c *Cache) remove(entry *CacheEntry) {
+	heap.Remove(&c.order, entry.index)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size()
+}
+
+// drop removes an entry that is being evicted or has expired.
+func (c *Cache) drop(entry *CacheEntry) {
+	c.remove(entry)
+	if c.onRemove != nil {
+		c.onRemove(entry.key)
 	}
-}
\ No newline at end of file
+}
+
+// evictionHeap orders entries with the next one to evict first.
+type evictionHeap struct {
+	entries []*CacheEntry
+	lfu     bool
+}
+
+func (h evictionHeap) Len() int { return len(h.entries) }
+
+func (h evictionHeap) Less(i, j int) bool {
+	a, b := h.entries[i], h.entries[j]
+	if h.lfu && a.hits != b.hits {
+		return a.hits < b.hits
+	}
+	return a.used < b.used
+}
+
+func (h evictionHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].index = i
+	h.entries[j].index = j
+}
+
+func (h *evictionHeap) Push(x any) {
+	entry := x.(*CacheEntry)
+	entry.index = len(h.entries)
+	h.entries = append(h.entries, entry)
+}
+
+func (h *evictionHeap) Pop() any {
+	last := h.entries[len(h.entries)-1]
+	h.entries[len(h.entries)-1] = nil
+	h.entries = h.entries[:len(h.entries)-1]
+	return last
+}
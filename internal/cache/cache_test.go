@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is safe to read from the janitor while a test advances it.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func newTestCache(t *testing.T, opts Options) (*Cache, *fakeClock) {
+	t.Helper()
+
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	c := New(opts)
+	c.mu.Lock()
+	c.now = clock.now
+	c.mu.Unlock()
+	t.Cleanup(func() { c.Close() })
+	return c, clock
+}
+
+func TestExpiry(t *testing.T) {
+	c, clock := newTestCache(t, Options{TTL: time.Minute})
+
+	c.Set("a", []byte("response"), 0)
+	if data, _, ok := c.Get("a"); !ok || string(data) != "response" {
+		t.Fatalf("Expected a cached response, got %q, %v", data, ok)
+	}
+
+	clock.advance(2 * time.Minute)
+	if _, _, ok := c.Get("a"); ok {
+		t.Error("Expected the entry to have expired")
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Expirations != 1 || stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	testCases := []struct {
+		name     string
+		opts     Options
+		access   []string // Keys read after a, b and c are stored
+		expected []string // Keys still cached after d is stored
+	}{
+		{
+			name:     "lru by entries",
+			opts:     Options{MaxEntries: 3},
+			access:   []string{"a"},
+			expected: []string{"a", "c", "d"},
+		},
+		{
+			name:     "lru by bytes",
+			opts:     Options{MaxBytes: 30},
+			access:   []string{"a", "b"},
+			expected: []string{"a", "b", "d"},
+		},
+		{
+			name:     "lfu",
+			opts:     Options{MaxEntries: 3, Eviction: LFU},
+			access:   []string{"a", "a", "c", "b", "c"},
+			expected: []string{"a", "c", "d"},
+		},
+		{
+			name:     "lfu ties go to the least recently used",
+			opts:     Options{MaxEntries: 3, Eviction: LFU},
+			access:   []string{"c", "b", "a"},
+			expected: []string{"a", "b", "d"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := newTestCache(t, tc.opts)
+
+			// Each entry is 10 bytes: a one-byte key and nine bytes of data
+			value := []byte(strings.Repeat("x", 9))
+			for _, key := range []string{"a", "b", "c"} {
+				c.Set(key, value, 0)
+			}
+			for _, key := range tc.access {
+				c.Get(key)
+			}
+			c.Set("d", value, 0)
+
+			stats := c.Stats()
+			if stats.Entries != 3 || stats.Evictions != 1 {
+				t.Errorf("Expected 3 entries after 1 eviction, got %+v", stats)
+			}
+			for _, key := range tc.expected {
+				if _, _, ok := c.Get(key); !ok {
+					t.Errorf("Expected %s to still be cached", key)
+				}
+			}
+		})
+	}
+}
+
+func TestOversizedEntry(t *testing.T) {
+	c, _ := newTestCache(t, Options{MaxBytes: 20})
+
+	c.Set("a", []byte("small"), 0)
+	c.Set("b", []byte(strings.Repeat("x", 100)), 0)
+
+	if _, _, ok := c.Get("b"); ok {
+		t.Error("Expected an entry larger than the cache not to be stored")
+	}
+	if _, _, ok := c.Get("a"); !ok {
+		t.Error("Expected the existing entry to be kept")
+	}
+}
+
+func TestReplaceEntry(t *testing.T) {
+	c, _ := newTestCache(t, Options{})
+
+	c.Set("a", []byte("first"), 0)
+	c.Set("a", []byte("second response"), 0)
+
+	if data, _, _ := c.Get("a"); string(data) != "second response" {
+		t.Errorf("Expected the replaced response, got %q", data)
+	}
+	if stats := c.Stats(); stats.Entries != 1 || stats.Bytes != len("a")+len("second response") {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	c, clock := newTestCache(t, Options{TTL: time.Minute})
+
+	c.Set("a", []byte("old"), 0)
+	clock.advance(30 * time.Second)
+	c.Set("b", []byte("new"), 0)
+	clock.advance(45 * time.Second)
+
+	c.sweep()
+	stats := c.Stats()
+	if stats.Entries != 1 || stats.Expirations != 1 {
+		t.Errorf("Expected the expired entry to be swept, got %+v", stats)
+	}
+	if _, _, ok := c.Get("b"); !ok {
+		t.Error("Expected the live entry to survive the sweep")
+	}
+
+	// The janitor sweeps on its own
+	c2, clock2 := newTestCache(t, Options{TTL: time.Minute, Sweep: 10 * time.Millisecond})
+	c2.Set("a", []byte("old"), 0)
+	clock2.advance(2 * time.Minute)
+
+	deadline := time.Now().Add(time.Second)
+	for c2.Stats().Entries != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the janitor to sweep the expired entry")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Closing twice is fine
+	if err := c2.Close(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := c2.Close(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
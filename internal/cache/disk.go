@@ -0,0 +1,333 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// diskMagic starts every entry file, so that stray files in the directory
+// are never mistaken for entries.
+const diskMagic = "RXC2"
+
+// tmpPrefix marks entry files that are still being written.
+const tmpPrefix = ".tmp-"
+
+// DiskStore is a memory cache whose entries are also written to a
+// directory, one file per entry, so that they survive a restart. Entries
+// are served from memory; the files are only read when the store is
+// opened, and are written in the background so that no cache operation
+// waits for the disk.
+type DiskStore struct {
+	*Cache
+	dir    string
+	errors atomic.Int64
+
+	// dirty holds the keys whose entries changed since their files were
+	// last brought in line with memory, and cleared whether the cache was
+	// cleared since. Both are guarded by the memory cache's lock.
+	dirty   map[string]struct{}
+	cleared bool
+
+	// files is held while the files are synced. The memory cache's lock
+	// is never waited on with it held.
+	files sync.Mutex
+
+	wake      chan struct{}
+	stop      chan struct{}
+	closeOnce sync.Once
+	done      sync.WaitGroup
+}
+
+// OpenDisk opens the disk store in opts.Path, creating the directory if
+// needed and loading the entries that haven't expired.
+func OpenDisk(opts Options) (*DiskStore, error) {
+	if opts.Path == "" {
+		return nil, errors.New("disk cache needs a path")
+	}
+	if err := os.MkdirAll(opts.Path, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	d := &DiskStore{
+		Cache: New(opts),
+		dir:   opts.Path,
+		dirty: make(map[string]struct{}),
+		wake:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+	}
+	if err := d.load(); err != nil {
+		d.Cache.Close()
+		return nil, err
+	}
+	d.Cache.onRemove = d.changed
+
+	d.done.Add(1)
+	go d.writer()
+	return d, nil
+}
+
+// Set stores data under key in memory and, if the memory cache keeps it,
+// on disk.
+func (d *DiskStore) Set(key string, data []byte, ttl time.Duration) {
+	d.Cache.mu.Lock()
+	defer d.Cache.mu.Unlock()
+
+	if ttl <= 0 {
+		ttl = d.Cache.ttl
+	}
+	now := d.Cache.now()
+	d.Cache.set(key, data, now, now.Add(ttl))
+	// Even if the entry isn't kept, it has replaced any earlier one
+	d.changed(key)
+}
+
+// Clear drops every entry from memory and disk.
+func (d *DiskStore) Clear() {
+	d.Cache.mu.Lock()
+	defer d.Cache.mu.Unlock()
+
+	d.Cache.clear()
+	d.dirty = make(map[string]struct{})
+	d.cleared = true
+	d.signal()
+}
+
+// Close writes out any pending changes and stops the background writer
+// and the memory cache's janitor. The store can still be used afterwards,
+// but its changes are no longer written to disk.
+func (d *DiskStore) Close() error {
+	d.closeOnce.Do(func() { close(d.stop) })
+	d.done.Wait()
+	d.sync()
+	return d.Cache.Close()
+}
+
+// Stats returns the store's counters.
+func (d *DiskStore) Stats() Stats {
+	stats := d.Cache.Stats()
+	stats.Errors += d.errors.Load()
+	return stats
+}
+
+func (d *DiskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:]))
+}
+
+// isEntryFile reports whether name looks like an entry file or a leftover
+// temporary one.
+func isEntryFile(name string) bool {
+	if strings.HasPrefix(name, tmpPrefix) {
+		return true
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil && len(name) == 2*sha256.Size
+}
+
+// changed marks key's file as out of date. The memory cache's lock must
+// be held.
+func (d *DiskStore) changed(key string) {
+	d.dirty[key] = struct{}{}
+	d.signal()
+}
+
+func (d *DiskStore) signal() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// writer syncs the files whenever entries change, until the store is
+// closed.
+func (d *DiskStore) writer() {
+	defer d.done.Done()
+
+	for {
+		select {
+		case <-d.wake:
+			d.sync()
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// sync brings the files of the changed entries in line with memory. Each
+// file is written from the entry as it is when the file is reached, so a
+// change made meanwhile is either picked up or marks the file again.
+func (d *DiskStore) sync() {
+	d.files.Lock()
+	defer d.files.Unlock()
+
+	for {
+		d.Cache.mu.Lock()
+		dirty, cleared := d.dirty, d.cleared
+		d.dirty, d.cleared = make(map[string]struct{}), false
+		d.Cache.mu.Unlock()
+
+		if len(dirty) == 0 && !cleared {
+			return
+		}
+		if cleared {
+			d.removeAll()
+		}
+		for key := range dirty {
+			d.syncFile(key)
+		}
+	}
+}
+
+func (d *DiskStore) syncFile(key string) {
+	d.Cache.mu.Lock()
+	entry, ok := d.Cache.entries[key]
+	var data []byte
+	var storedAt, expiresAt time.Time
+	if ok {
+		data, storedAt, expiresAt = entry.Data, entry.StoredAt, entry.ExpiresAt
+	}
+	d.Cache.mu.Unlock()
+
+	if !ok {
+		d.removeFile(key)
+		return
+	}
+	if err := d.writeFile(key, data, storedAt, expiresAt); err != nil {
+		d.errors.Add(1)
+		log.Printf("Error writing cache entry: %v", err)
+	}
+}
+
+// removeAll deletes every entry file in the directory.
+func (d *DiskStore) removeAll() {
+	files, err := os.ReadDir(d.dir)
+	if err != nil {
+		d.errors.Add(1)
+		log.Printf("Error clearing cache directory: %v", err)
+		return
+	}
+	for _, file := range files {
+		if isEntryFile(file.Name()) {
+			os.Remove(filepath.Join(d.dir, file.Name()))
+		}
+	}
+}
+
+// removeFile deletes key's file.
+func (d *DiskStore) removeFile(key string) {
+	if err := os.Remove(d.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		d.errors.Add(1)
+	}
+}
+
+// writeFile writes an entry to a temporary file and renames it into place,
+// so a crash never leaves a partial entry behind.
+func (d *DiskStore) writeFile(key string, data []byte, storedAt, expiresAt time.Time) error {
+	f, err := os.CreateTemp(d.dir, tmpPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(encodeEntry(key, data, storedAt, expiresAt)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), d.path(key))
+}
+
+// load reads the directory's entries into memory, oldest first so that
+// the memory cache's limits drop the entries closest to expiry. Expired,
+// unreadable and half-written files are deleted.
+func (d *DiskStore) load() error {
+	files, err := os.ReadDir(d.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	type loaded struct {
+		key       string
+		data      []byte
+		storedAt  time.Time
+		expiresAt time.Time
+	}
+	var entries []loaded
+	now := d.Cache.now()
+	for _, file := range files {
+		name := file.Name()
+		if !isEntryFile(name) {
+			continue
+		}
+		path := filepath.Join(d.dir, name)
+
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		key, data, storedAt, expiresAt, ok := decodeEntry(raw)
+		if !ok || strings.HasPrefix(name, tmpPrefix) || !now.Before(expiresAt) || path != d.path(key) {
+			os.Remove(path)
+			continue
+		}
+		entries = append(entries, loaded{key, data, storedAt, expiresAt})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].expiresAt.Before(entries[j].expiresAt)
+	})
+
+	d.Cache.mu.Lock()
+	defer d.Cache.mu.Unlock()
+	for _, e := range entries {
+		d.Cache.set(e.key, e.data, e.storedAt, e.expiresAt)
+	}
+	// Entries that didn't fit are gone for good
+	for _, e := range entries {
+		if _, ok := d.Cache.entries[e.key]; !ok {
+			os.Remove(d.path(e.key))
+		}
+	}
+	return nil
+}
+
+// encodeEntry lays out an entry file: the magic, the times it was stored
+// and expires in Unix nanoseconds, the key's length and the key, then the
+// data.
+func encodeEntry(key string, data []byte, storedAt, expiresAt time.Time) []byte {
+	buf := make([]byte, 0, len(diskMagic)+20+len(key)+len(data))
+	buf = append(buf, diskMagic...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(storedAt.UnixNano()))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(expiresAt.UnixNano()))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(key)))
+	buf = append(buf, key...)
+	return append(buf, data...)
+}
+
+func decodeEntry(raw []byte) (key string, data []byte, storedAt, expiresAt time.Time, ok bool) {
+	header := len(diskMagic) + 20
+	if len(raw) < header || string(raw[:len(diskMagic)]) != diskMagic {
+		return "", nil, time.Time{}, time.Time{}, false
+	}
+	storedAt = time.Unix(0, int64(binary.BigEndian.Uint64(raw[len(diskMagic):])))
+	expiresAt = time.Unix(0, int64(binary.BigEndian.Uint64(raw[len(diskMagic)+8:])))
+	keyLen := int(binary.BigEndian.Uint32(raw[len(diskMagic)+16:]))
+	if len(raw) < header+keyLen {
+		return "", nil, time.Time{}, time.Time{}, false
+	}
+	key = string(raw[header : header+keyLen])
+	return key, raw[header+keyLen:], storedAt, expiresAt, true
+}
@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func openTestDisk(t *testing.T, opts Options) *DiskStore {
+	t.Helper()
+
+	d, err := OpenDisk(opts)
+	if err != nil {
+		t.Fatalf("Failed to open disk store: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func entryFiles(t *testing.T, dir string) int {
+	t.Helper()
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read cache directory: %v", err)
+	}
+	return len(files)
+}
+
+func TestDiskPersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	d := openTestDisk(t, Options{Path: dir, TTL: time.Minute})
+	d.Set("a", []byte("first"), 0)
+	d.Set("b", []byte("second"), 0)
+	d.Set("a", []byte("replaced"), 0)
+	d.Close()
+
+	reopened := openTestDisk(t, Options{Path: dir, TTL: time.Minute})
+	if data, _, ok := reopened.Get("a"); !ok || string(data) != "replaced" {
+		t.Errorf("Expected the replaced entry after reopening, got %q, %v", data, ok)
+	}
+	if data, _, ok := reopened.Get("b"); !ok || string(data) != "second" {
+		t.Errorf("Expected the second entry after reopening, got %q, %v", data, ok)
+	}
+	if stats := reopened.Stats(); stats.Entries != 2 {
+		t.Errorf("Expected 2 entries, got %d", stats.Entries)
+	}
+}
+
+func TestDiskDropsExpiredEntries(t *testing.T) {
+	dir := t.TempDir()
+
+	d := openTestDisk(t, Options{Path: dir, TTL: 50 * time.Millisecond})
+	d.Set("a", []byte("response"), 0)
+	d.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	reopened := openTestDisk(t, Options{Path: dir, TTL: time.Minute})
+	if _, _, ok := reopened.Get("a"); ok {
+		t.Error("Expected the expired entry not to be loaded")
+	}
+	if n := entryFiles(t, dir); n != 0 {
+		t.Errorf("Expected the expired entry's file to be deleted, got %d files", n)
+	}
+}
+
+func TestDiskEvictionAndClear(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDisk(t, Options{Path: dir, MaxEntries: 2})
+
+	d.Set("a", []byte("1"), 0)
+	d.Set("b", []byte("2"), 0)
+	d.Set("c", []byte("3"), 0)
+	d.sync()
+	if n := entryFiles(t, dir); n != 2 {
+		t.Errorf("Expected the evicted entry's file to be deleted, got %d files", n)
+	}
+	if _, _, ok := d.Get("a"); ok {
+		t.Error("Expected the oldest entry to have been evicted")
+	}
+
+	os.WriteFile(dir+"/notes.txt", []byte("not an entry"), 0o600)
+	d.Clear()
+	d.sync()
+	if n := entryFiles(t, dir); n != 1 {
+		t.Errorf("Expected only the unrelated file to remain, got %d files", n)
+	}
+	if _, _, ok := d.Get("b"); ok {
+		t.Error("Expected the cache to be empty after clearing")
+	}
+}
+
+func TestDiskOnlyWritesKeptEntries(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDisk(t, Options{Path: dir, MaxBytes: 16})
+
+	d.Set("a", []byte("small"), 0)
+	d.sync()
+	if n := entryFiles(t, dir); n != 1 {
+		t.Fatalf("Expected 1 file, got %d", n)
+	}
+
+	// Too large for the memory cache, so neither it nor the entry it
+	// replaces survive a restart
+	d.Set("a", []byte("far too large to keep"), 0)
+	d.Set("b", []byte("also far too large"), 0)
+	d.sync()
+	if n := entryFiles(t, dir); n != 0 {
+		t.Errorf("Expected no files for entries that weren't kept, got %d", n)
+	}
+	d.Close()
+
+	reopened := openTestDisk(t, Options{Path: dir, MaxBytes: 16})
+	if data, _, ok := reopened.Get("a"); ok {
+		t.Errorf("Expected the replaced entry to be gone, got %q", data)
+	}
+}
+
+func TestDiskWritesDontBlockTheCache(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDisk(t, Options{Path: dir, MaxEntries: 1})
+
+	// Stand in for a sync stuck on a slow disk
+	d.files.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Set("a", []byte("1"), 0)
+		d.Set("b", []byte("2"), 0)
+		d.Get("a")
+		d.Clear()
+		d.Set("c", []byte("3"), 0)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected cache operations not to wait for the disk")
+	}
+	d.files.Unlock()
+
+	d.sync()
+	if n := entryFiles(t, dir); n != 1 {
+		t.Errorf("Expected only the last entry's file, got %d files", n)
+	}
+	d.Close()
+
+	reopened := openTestDisk(t, Options{Path: dir})
+	if data, _, ok := reopened.Get("c"); !ok || string(data) != "3" {
+		t.Errorf("Expected the last entry after reopening, got %q, %v", data, ok)
+	}
+}
@@ -0,0 +1,303 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults for the Redis backend.
+const (
+	DefaultRedisPrefix  = "roxy:"
+	DefaultRedisTimeout = time.Second
+
+	// redisIdleConns is how many connections are kept open between
+	// requests.
+	redisIdleConns = 8
+)
+
+// RedisOptions configure the Redis backend.
+type RedisOptions struct {
+	Addr      string // host:port
+	Password  string
+	DB        int
+	KeyPrefix string        // Prepended to every key, so that a server can be shared
+	Timeout   time.Duration // Per command, including dialling
+}
+
+// RedisStore keeps entries in a Redis server, or anything speaking its
+// protocol, so that several proxies can share a cache. The server enforces
+// the TTL and its own memory limits. Each value is the time the entry was
+// stored, in Unix milliseconds, followed by its data.
+type RedisStore struct {
+	opts RedisOptions
+	ttl  time.Duration
+	idle chan *redisConn
+
+	hits, misses, errors atomic.Int64
+}
+
+// OpenRedis connects to the server in opts.Redis, failing if it can't be
+// reached.
+func OpenRedis(opts Options) (*RedisStore, error) {
+	if opts.Redis.Addr == "" {
+		return nil, errors.New("redis cache needs an address")
+	}
+	if opts.Redis.KeyPrefix == "" {
+		opts.Redis.KeyPrefix = DefaultRedisPrefix
+	}
+	if opts.Redis.Timeout <= 0 {
+		opts.Redis.Timeout = DefaultRedisTimeout
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+
+	s := &RedisStore{
+		opts: opts.Redis,
+		ttl:  opts.TTL,
+		idle: make(chan *redisConn, redisIdleConns),
+	}
+	if _, err := s.do("PING"); err != nil {
+		return nil, fmt.Errorf("failed to reach redis at %s: %w", opts.Redis.Addr, err)
+	}
+	return s, nil
+}
+
+// Get retrieves key's data if the server has it.
+func (s *RedisStore) Get(key string) ([]byte, time.Time, bool) {
+	reply, err := s.do("GET", s.opts.KeyPrefix+key)
+	if err != nil {
+		s.fail("reading", err)
+		s.misses.Add(1)
+		return nil, time.Time{}, false
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		s.misses.Add(1)
+		return nil, time.Time{}, false
+	}
+	if len(value) < 8 {
+		s.fail("reading", errors.New("malformed entry"))
+		s.misses.Add(1)
+		return nil, time.Time{}, false
+	}
+	s.hits.Add(1)
+	storedAt := time.UnixMilli(int64(binary.BigEndian.Uint64(value)))
+	return value[8:], storedAt, true
+}
+
+// Set stores data under key for ttl, or the store's TTL if ttl is zero.
+func (s *RedisStore) Set(key string, data []byte, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = s.ttl
+	}
+	value := binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(data)), uint64(time.Now().UnixMilli()))
+	value = append(value, data...)
+
+	px := strconv.FormatInt(max(ttl.Milliseconds(), 1), 10)
+	if _, err := s.do("SET", s.opts.KeyPrefix+key, string(value), "PX", px); err != nil {
+		s.fail("writing", err)
+	}
+}
+
+// Clear deletes every key under the store's prefix.
+func (s *RedisStore) Clear() {
+	cursor := "0"
+	for {
+		reply, err := s.do("SCAN", cursor, "MATCH", s.opts.KeyPrefix+"*", "COUNT", "500")
+		if err != nil {
+			s.fail("clearing", err)
+			return
+		}
+		page, ok := reply.([]any)
+		if !ok || len(page) != 2 {
+			s.fail("clearing", errors.New("malformed SCAN reply"))
+			return
+		}
+		next, _ := page[0].([]byte)
+		keys, _ := page[1].([]any)
+
+		if len(keys) > 0 {
+			args := []string{"DEL"}
+			for _, k := range keys {
+				if k, ok := k.([]byte); ok {
+					args = append(args, string(k))
+				}
+			}
+			if _, err := s.do(args...); err != nil {
+				s.fail("clearing", err)
+				return
+			}
+		}
+
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return
+		}
+	}
+}
+
+// Stats returns the hits, misses and errors this proxy has seen. The
+// size of the shared cache isn't tracked.
+func (s *RedisStore) Stats() Stats {
+	return Stats{
+		Hits:   s.hits.Load(),
+		Misses: s.misses.Load(),
+		Errors: s.errors.Load(),
+	}
+}
+
+// Close closes the idle connections.
+func (s *RedisStore) Close() error {
+	for {
+		select {
+		case c := <-s.idle:
+			c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (s *RedisStore) fail(action string, err error) {
+	s.errors.Add(1)
+	log.Printf("Error %s redis cache: %v", action, err)
+}
+
+// do sends a command and returns its reply: nil, an int64, a []byte for
+// strings, or a []any for arrays. Error replies are returned as errors.
+func (s *RedisStore) do(args ...string) (any, error) {
+	c, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := c.do(s.opts.Timeout, args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		// The connection is in an unknown state
+		c.conn.Close()
+		return nil, err
+	}
+
+	select {
+	case s.idle <- c:
+	default:
+		c.conn.Close()
+	}
+	return reply, err
+}
+
+// conn returns an idle connection or dials a new one.
+func (s *RedisStore) conn() (*redisConn, error) {
+	select {
+	case c := <-s.idle:
+		return c, nil
+	default:
+	}
+
+	conn, err := net.DialTimeout("tcp", s.opts.Addr, s.opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+
+	if s.opts.Password != "" {
+		if _, err := c.do(s.opts.Timeout, "AUTH", s.opts.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if s.opts.DB != 0 {
+		if _, err := c.do(s.opts.Timeout, "SELECT", strconv.Itoa(s.opts.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *redisConn) do(timeout time.Duration, args ...string) (any, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, "\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+// readReply reads one RESP reply.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply: %q", line)
+	}
+	kind, rest := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return []byte(rest), nil
+	case '-':
+		return nil, redisError(rest)
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("malformed reply: %q", line)
+}
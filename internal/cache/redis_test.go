@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CiaranMcAleer/roxy/internal/testutils"
+)
+
+func TestRedisStore(t *testing.T) {
+	server := testutils.MockRedisServer("secret")
+	defer server.Close()
+
+	s, err := OpenRedis(Options{TTL: time.Minute, Redis: RedisOptions{Addr: server.Addr, Password: "secret", DB: 1}})
+	if err != nil {
+		t.Fatalf("Failed to open redis store: %v", err)
+	}
+	defer s.Close()
+
+	if _, _, ok := s.Get("a"); ok {
+		t.Error("Expected a miss on an empty cache")
+	}
+	s.Set("a", []byte("response\r\nwith a line break"), 0)
+	if data, _, ok := s.Get("a"); !ok || string(data) != "response\r\nwith a line break" {
+		t.Errorf("Expected the cached response, got %q, %v", data, ok)
+	}
+	if keys := server.Keys(); len(keys) != 1 || keys[0] != "roxy:a" {
+		t.Errorf("Expected the key under the default prefix, got %v", keys)
+	}
+
+	server.Expire()
+	if _, _, ok := s.Get("a"); ok {
+		t.Error("Expected the entry to have expired")
+	}
+
+	s.Set("b", []byte("1"), 0)
+	s.Set("c", []byte("2"), 0)
+	s.Clear()
+	if keys := server.Keys(); len(keys) != 0 {
+		t.Errorf("Expected no keys after clearing, got %v", keys)
+	}
+
+	stats := s.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 || stats.Errors != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestRedisStoreFailures(t *testing.T) {
+	server := testutils.MockRedisServer("secret")
+
+	if _, err := OpenRedis(Options{Redis: RedisOptions{Addr: server.Addr, Password: "wrong"}}); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("Expected a wrong password to fail, got %v", err)
+	}
+
+	s, err := OpenRedis(Options{Redis: RedisOptions{Addr: server.Addr, Password: "secret", Timeout: 100 * time.Millisecond}})
+	if err != nil {
+		t.Fatalf("Failed to open redis store: %v", err)
+	}
+	defer s.Close()
+
+	// Once the server is gone the cache misses rather than failing requests
+	server.Close()
+	s.Set("a", []byte("response"), 0)
+	if _, _, ok := s.Get("a"); ok {
+		t.Error("Expected a miss with the server gone")
+	}
+	if stats := s.Stats(); stats.Errors != 2 || stats.Misses != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+)
+
+// Backends a cache can be kept in.
+const (
+	Memory = "memory" // In-process, lost on restart (default)
+	Disk   = "disk"   // In-process, persisted to a directory
+	Redis  = "redis"  // Shared through a Redis server
+)
+
+// Store is a cache of response bodies by key. Caching is best effort:
+// a backend that fails to read or write counts the error in its Stats and
+// behaves as if the entry were missing.
+type Store interface {
+	// Get returns key's data and when it was stored.
+	Get(key string) ([]byte, time.Time, bool)
+
+	// Set stores data under key for ttl, or the store's TTL if ttl is
+	// zero.
+	Set(key string, data []byte, ttl time.Duration)
+
+	// Clear drops every entry.
+	Clear()
+
+	// Stats returns the store's counters. Backends that don't track the
+	// size of the cache leave Entries and Bytes at zero.
+	Stats() Stats
+
+	// Close releases the store's resources.
+	Close() error
+}
+
+// Open returns the store for opts.Backend.
+func Open(opts Options) (Store, error) {
+	switch opts.Backend {
+	case "", Memory:
+		return New(opts), nil
+	case Disk:
+		return OpenDisk(opts)
+	case Redis:
+		return OpenRedis(opts)
+	}
+	return nil, fmt.Errorf("unknown cache backend: %s", opts.Backend)
+}
@@ -19,6 +19,98 @@ type Config struct {
 
 	// Provider configurations
 	Providers ProviderConfig `yaml:"providers"`
+
+	// Circuit breaker thresholds for failing keys and providers
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+
+	// Retry and failover behaviour for failed provider requests
+	Retry RetryConfig `yaml:"retry"`
+
+	// Model prices and capabilities, used by the cheapest selection policy
+	Models []ModelInfo `yaml:"models"`
+
+	// File of further model catalogue entries; entries in models take
+	// precedence over the file's
+	ModelCatalogue string `yaml:"model_catalogue"`
+
+	// Provider for each model name or glob that doesn't name its provider
+	ModelProviders map[string]string `yaml:"model_providers"`
+
+	// Response cache backend and limits
+	Cache CacheConfig `yaml:"cache"`
+}
+
+// CacheConfig selects and bounds the response cache. Zero values use the
+// defaults.
+type CacheConfig struct {
+	Backend    string `yaml:"backend"`     // memory (default), disk or redis
+	TTLSec     int    `yaml:"ttl_sec"`     // How long a response is served from the cache (default 300)
+	MaxEntries int    `yaml:"max_entries"` // Responses kept at most (default 10000)
+	MaxBytes   int    `yaml:"max_bytes"`   // Total size of the responses kept at most (default 64 MiB)
+	Eviction   string `yaml:"eviction"`    // lru (default) or lfu
+	SweepSec   int    `yaml:"sweep_sec"`   // How often expired responses are dropped (default 60)
+
+	Path  string      `yaml:"path"` // Directory of the disk backend
+	Redis RedisConfig `yaml:"redis"`
+
+	// Whose cached responses a request may see: key (default) for clients
+	// sending the same API key, user for the same request user field,
+	// header:<name> for the same header value, or none to share them all
+	NamespaceBy string `yaml:"namespace_by"`
+
+	// Which model a response is cached under: source (default) for the
+	// model the client asked for, or target for the model that answered
+	KeyModel string `yaml:"key_model"`
+}
+
+// RedisConfig points the redis cache backend at a server. Entry limits
+// are left to the server.
+type RedisConfig struct {
+	Addr           string `yaml:"addr"` // host:port
+	Password       string `yaml:"password"`
+	PasswordEnvVar string `yaml:"password_env_var"` // Environment variable name for the password
+	DB             int    `yaml:"db"`
+	KeyPrefix      string `yaml:"key_prefix"` // Prepended to every key (default "roxy:")
+	TimeoutMs      int    `yaml:"timeout_ms"` // Per command (default 1000)
+}
+
+func (c CacheConfig) validate() error {
+	if c.TTLSec < 0 || c.MaxEntries < 0 || c.MaxBytes < 0 || c.SweepSec < 0 {
+		return fmt.Errorf("cache: values must not be negative")
+	}
+	if c.Eviction != "" && c.Eviction != "lru" && c.Eviction != "lfu" {
+		return fmt.Errorf("cache: invalid eviction: %s", c.Eviction)
+	}
+
+	switch c.Backend {
+	case "", "memory":
+	case "disk":
+		if c.Path == "" {
+			return fmt.Errorf("cache: path is required for the disk backend")
+		}
+	case "redis":
+		if c.Redis.Addr == "" {
+			return fmt.Errorf("cache: redis.addr is required for the redis backend")
+		}
+		if c.Redis.DB < 0 || c.Redis.TimeoutMs < 0 {
+			return fmt.Errorf("cache: redis values must not be negative")
+		}
+	default:
+		return fmt.Errorf("cache: invalid backend: %s", c.Backend)
+	}
+
+	switch c.NamespaceBy {
+	case "", "key", "user", "none":
+	default:
+		name, ok := strings.CutPrefix(c.NamespaceBy, "header:")
+		if !ok || name == "" {
+			return fmt.Errorf("cache: invalid namespace_by: %s", c.NamespaceBy)
+		}
+	}
+	if c.KeyModel != "" && c.KeyModel != "source" && c.KeyModel != "target" {
+		return fmt.Errorf("cache: invalid key_model: %s", c.KeyModel)
+	}
+	return nil
 }
 
 type APIKeyConfig struct {
@@ -28,27 +120,201 @@ type APIKeyConfig struct {
 	MaxRPM      int    `yaml:"max_rpm"`      // Requests per minute
 	MaxTPM      int    `yaml:"max_tpm"`      // Tokens per minute
 	CooldownSec int    `yaml:"cooldown_sec"` // Cooldown period in seconds
+	Weight      int    `yaml:"weight"`       // Share of traffic under the weighted key strategy
+}
+
+// CircuitBreakerConfig sets when failing keys and providers are taken out
+// of rotation. Zero values use the defaults.
+type CircuitBreakerConfig struct {
+	KeyFailures      int `yaml:"key_failures"`      // Consecutive failures before a key is skipped (default 5)
+	ProviderFailures int `yaml:"provider_failures"` // Consecutive failures before a provider is skipped (default 10)
+	OpenSec          int `yaml:"open_sec"`          // Seconds to wait before probing again (default 30)
+	HalfOpenProbes   int `yaml:"half_open_probes"`  // Concurrent probe requests while recovering (default 1)
+}
+
+// RetryConfig sets how failed provider requests are retried on other keys
+// and models. Zero values use the defaults.
+type RetryConfig struct {
+	MaxAttempts  int      `yaml:"max_attempts"`   // Provider requests per client request, including the first (default 3)
+	BackoffMs    int      `yaml:"backoff_ms"`     // Delay before the first retry, doubled for each one after (default 100)
+	MaxBackoffMs int      `yaml:"max_backoff_ms"` // Upper bound on the delay between retries (default 2000)
+	RetryOn      []string `yaml:"retry_on"`       // rate_limited, overloaded, server_error, timeout, connection_error, auth
 }
 
 type ModelRule struct {
-	SourceModel     string   `yaml:"source_model"`
-	TargetModels    []string `yaml:"target_models"`
-	SelectionPolicy string   `yaml:"selection_policy"` // random, roundrobin, fallback
+	SourceModel     string     `yaml:"source_model"` // Model name, glob (gpt-4*) or regex (re:^claude-3-5-.*)
+	TargetModels    TargetList `yaml:"target_models"`
+	SelectionPolicy string     `yaml:"selection_policy"` // random, roundrobin, fallback, hedged, adaptive, cheapest, weighted
+	Timeouts        Timeouts   `yaml:"timeouts"`         // Overrides the target providers' timeouts
+	HedgeDelayMs    int        `yaml:"hedge_delay_ms"`   // Wait before racing a second target under hedged (default 500)
+
+	// Share of traffic per target under weighted. Targets left out get no
+	// traffic of their own but are still failed over to; with no weights
+	// every target gets an equal share.
+	Weights map[string]int `yaml:"weights"`
+	// Keeps a client on one target under weighted: "user" for the request's
+	// user field, or "header:<name>" for a request header
+	StickyBy string `yaml:"sticky_by"`
+
+	// Mirrors a sample of the rule's requests to candidate models
+	Shadow ShadowConfig `yaml:"shadow"`
+
+	// Which of the rule's responses are cached, and for how long
+	Cache RuleCacheConfig `yaml:"cache"`
+}
+
+// RuleCacheConfig overrides the response cache for a rule's requests.
+type RuleCacheConfig struct {
+	// always (default), deterministic to cache only requests with
+	// temperature 0 or a seed, or never
+	Mode   string `yaml:"mode"`
+	TTLSec int    `yaml:"ttl_sec"` // Overrides the cache's TTL
+}
+
+func (c RuleCacheConfig) validate() error {
+	if c.Mode != "" && c.Mode != "always" && c.Mode != "deterministic" && c.Mode != "never" {
+		return fmt.Errorf("cache: invalid mode: %s", c.Mode)
+	}
+	if c.TTLSec < 0 {
+		return fmt.Errorf("cache: ttl_sec must not be negative")
+	}
+	return nil
+}
+
+// ShadowConfig mirrors requests to candidate models in the background and
+// records their responses next to the one the client got, for evaluating
+// a model before routing to it.
+type ShadowConfig struct {
+	Models     TargetList `yaml:"models"`      // Candidate models to mirror requests to
+	SampleRate float64    `yaml:"sample_rate"` // Share of requests mirrored, from 0 to 1
+	Output     string     `yaml:"output"`      // JSONL file the paired responses are appended to
+}
+
+func (s ShadowConfig) validate() error {
+	if s.SampleRate < 0 || s.SampleRate > 1 {
+		return fmt.Errorf("shadow: sample_rate must be between 0 and 1")
+	}
+	if len(s.Models) > 0 && s.Output == "" {
+		return fmt.Errorf("shadow: output is required")
+	}
+	return nil
+}
+
+// TargetWeight returns the share of traffic model gets under the weighted
+// policy.
+func (r *ModelRule) TargetWeight(model string) int {
+	if len(r.Weights) == 0 {
+		return 1
+	}
+	return r.Weights[model]
+}
+
+func (r *ModelRule) validateWeights() error {
+	total := 0
+	for model, weight := range r.Weights {
+		found := false
+		for _, target := range r.TargetModels {
+			if target == model {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("weights: %s is not a target model", model)
+		}
+		if weight < 0 {
+			return fmt.Errorf("weights: weight must not be negative")
+		}
+		total += weight
+	}
+	if len(r.Weights) > 0 && total == 0 {
+		return fmt.Errorf("weights: at least one target needs a positive weight")
+	}
+
+	if r.StickyBy != "" && r.StickyBy != "user" {
+		name, ok := strings.CutPrefix(r.StickyBy, "header:")
+		if !ok || name == "" {
+			return fmt.Errorf("invalid sticky_by: %s", r.StickyBy)
+		}
+	}
+	return nil
+}
+
+// Timeouts bound requests to a provider. Zero values fall back to the
+// provider's setting and then to the defaults.
+type Timeouts struct {
+	ConnectSec   int `yaml:"connect_sec"`    // Establishing the connection (default 10)
+	FirstByteSec int `yaml:"first_byte_sec"` // Waiting for the response headers (default 120)
+	TotalSec     int `yaml:"total_sec"`      // The whole request including the body (default unlimited)
+	IdleSec      int `yaml:"idle_sec"`       // Between chunks of the response body (default 60)
+}
+
+func (t Timeouts) validate() error {
+	if t.ConnectSec < 0 || t.FirstByteSec < 0 || t.TotalSec < 0 || t.IdleSec < 0 {
+		return fmt.Errorf("timeouts must not be negative")
+	}
+	return nil
+}
+
+// ModelInfo describes a model in the catalogue.
+type ModelInfo struct {
+	Name          string   `yaml:"name"`
+	InputPrice    float64  `yaml:"input_price"`    // USD per prompt token
+	OutputPrice   float64  `yaml:"output_price"`   // USD per completion token
+	ContextWindow int      `yaml:"context_window"` // Prompt and completion tokens combined (0 for unknown)
+	Capabilities  []string `yaml:"capabilities"`   // tools, vision, json_mode
+}
+
+// Supports reports whether the model has every one of capabilities.
+func (m ModelInfo) Supports(capabilities []string) bool {
+	for _, want := range capabilities {
+		found := false
+		for _, have := range m.Capabilities {
+			if strings.EqualFold(have, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
 }
 
 type ProviderConfig struct {
-	OpenAI struct {
-		BaseURL string `yaml:"base_url"`
-	} `yaml:"openai"`
-	Anthropic struct {
-		BaseURL string `yaml:"base_url"`
-	} `yaml:"anthropic"`
-	OpenRouter struct {
-		BaseURL string `yaml:"base_url"`
-	} `yaml:"openrouter"`
-	Chutes struct {
-		BaseURL string `yaml:"base_url"`
-	} `yaml:"chutes"`
+	OpenAI     ProviderEndpoint   `yaml:"openai"`
+	Anthropic  ProviderEndpoint   `yaml:"anthropic"`
+	OpenRouter OpenRouterEndpoint `yaml:"openrouter"`
+	Chutes     ProviderEndpoint   `yaml:"chutes"`
+}
+
+// ProviderEndpoint holds the settings every provider accepts.
+type ProviderEndpoint struct {
+	BaseURL     string   `yaml:"base_url"`
+	KeyStrategy string   `yaml:"key_strategy"` // round_robin, lru, least_loaded, weighted
+	Timeouts    Timeouts `yaml:"timeouts"`
+}
+
+type OpenRouterEndpoint struct {
+	ProviderEndpoint `yaml:",inline"`
+	Referer          string `yaml:"referer"` // Sent as HTTP-Referer for OpenRouter app attribution
+	Title            string `yaml:"title"`   // Sent as X-Title for OpenRouter app attribution
+}
+
+// Endpoint returns the shared settings of the named provider.
+func (p *ProviderConfig) Endpoint(name string) ProviderEndpoint {
+	switch strings.ToLower(name) {
+	case "openai":
+		return p.OpenAI
+	case "anthropic":
+		return p.Anthropic
+	case "openrouter":
+		return p.OpenRouter.ProviderEndpoint
+	case "chutes":
+		return p.Chutes
+	}
+	return ProviderEndpoint{}
 }
 
 func Load(path string) (*Config, error) {
@@ -62,6 +328,14 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("parsing config file: %w", err)
 	}
 
+	if cfg.ModelCatalogue != "" {
+		models, err := LoadCatalogue(cfg.ModelCatalogue)
+		if err != nil {
+			return nil, fmt.Errorf("loading model catalogue: %w", err)
+		}
+		cfg.Models = append(models, cfg.Models...)
+	}
+
 	if err := cfg.loadSecrets(); err != nil {
 		return nil, fmt.Errorf("loading secrets: %w", err)
 	}
@@ -73,6 +347,45 @@ func Load(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+// LoadCatalogue reads model catalogue entries from a YAML file holding a
+// models list in the same format as the config's.
+func LoadCatalogue(path string) ([]ModelInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading catalogue file: %w", err)
+	}
+
+	var catalogue struct {
+		Models []ModelInfo `yaml:"models"`
+	}
+	if err := yaml.Unmarshal(data, &catalogue); err != nil {
+		return nil, fmt.Errorf("parsing catalogue file: %w", err)
+	}
+	return catalogue.Models, nil
+}
+
+// Catalogue returns the model catalogue by name. Later entries replace
+// earlier ones with the same name.
+func (c *Config) Catalogue() map[string]ModelInfo {
+	catalogue := make(map[string]ModelInfo, len(c.Models))
+	for _, model := range c.Models {
+		catalogue[model.Name] = model
+	}
+	return catalogue
+}
+
+// CatalogueEntry returns the entry in catalogue, as built by Catalogue, for
+// a target model, looked up by the target as written and then by the model
+// name sent to its provider.
+func (c *Config) CatalogueEntry(catalogue map[string]ModelInfo, model string) (ModelInfo, bool) {
+	if info, ok := catalogue[model]; ok {
+		return info, true
+	}
+	_, name, _ := c.ProviderFor(model)
+	info, ok := catalogue[name]
+	return info, ok
+}
+
 func (c *Config) loadSecrets() error {
 	for i, key := range c.APIKeys {
 		// If KeyEnvVar is specified, use it to load the key
@@ -84,6 +397,14 @@ func (c *Config) loadSecrets() error {
 			c.APIKeys[i].Key = envKey
 		}
 	}
+
+	if env := c.Cache.Redis.PasswordEnvVar; env != "" {
+		password := os.Getenv(env)
+		if password == "" {
+			return fmt.Errorf("environment variable %s not set for cache redis password", env)
+		}
+		c.Cache.Redis.Password = password
+	}
 	return nil
 }
 
@@ -112,8 +433,58 @@ func (c *Config) validate() error {
 		if !isValidProvider(key.Provider) {
 			return fmt.Errorf("api_keys[%d]: invalid provider %s", i, key.Provider)
 		}
+		if key.Weight < 0 {
+			return fmt.Errorf("api_keys[%d]: weight must not be negative", i)
+		}
 	}
 
+	for _, name := range ProviderNames() {
+		endpoint := c.Providers.Endpoint(name)
+		if endpoint.KeyStrategy != "" && !isValidKeyStrategy(endpoint.KeyStrategy) {
+			return fmt.Errorf("providers.%s: invalid key_strategy: %s", name, endpoint.KeyStrategy)
+		}
+		if err := endpoint.Timeouts.validate(); err != nil {
+			return fmt.Errorf("providers.%s: %w", name, err)
+		}
+	}
+
+	cb := c.CircuitBreaker
+	if cb.KeyFailures < 0 || cb.ProviderFailures < 0 || cb.OpenSec < 0 || cb.HalfOpenProbes < 0 {
+		return fmt.Errorf("circuit_breaker: values must not be negative")
+	}
+
+	if c.Retry.MaxAttempts < 0 || c.Retry.BackoffMs < 0 || c.Retry.MaxBackoffMs < 0 {
+		return fmt.Errorf("retry: values must not be negative")
+	}
+	for _, cond := range c.Retry.RetryOn {
+		if !isValidRetryCondition(cond) {
+			return fmt.Errorf("retry: invalid retry_on condition: %s", cond)
+		}
+	}
+
+	if err := c.validateModelProviders(); err != nil {
+		return err
+	}
+
+	if err := c.Cache.validate(); err != nil {
+		return err
+	}
+
+	for i, model := range c.Models {
+		if model.Name == "" {
+			return fmt.Errorf("models[%d]: name is required", i)
+		}
+		if model.InputPrice < 0 || model.OutputPrice < 0 || model.ContextWindow < 0 {
+			return fmt.Errorf("models[%d]: values must not be negative", i)
+		}
+		for _, capability := range model.Capabilities {
+			if !isValidCapability(capability) {
+				return fmt.Errorf("models[%d]: invalid capability: %s", i, capability)
+			}
+		}
+	}
+	catalogue := c.Catalogue()
+
 	for i, rule := range c.ModelRules {
 		if rule.SourceModel == "" {
 			return fmt.Errorf("model_rules[%d]: source_model is required", i)
@@ -121,25 +492,103 @@ func (c *Config) validate() error {
 		if len(rule.TargetModels) == 0 {
 			return fmt.Errorf("model_rules[%d]: at least one target_model is required", i)
 		}
+		if err := rule.validatePattern(); err != nil {
+			return fmt.Errorf("model_rules[%d]: %w", i, err)
+		}
+		for _, model := range append(append([]string{}, rule.TargetModels...), rule.Shadow.Models...) {
+			if strings.Contains(model, "$") {
+				continue // Only known once a request matches
+			}
+			if _, _, ok := c.ProviderFor(model); !ok {
+				return fmt.Errorf("model_rules[%d]: no provider for target model %s", i, model)
+			}
+		}
 		if rule.SelectionPolicy == "" {
 			return fmt.Errorf("model_rules[%d]: selection_policy is required", i)
 		}
 		if !isValidSelectionPolicy(rule.SelectionPolicy) {
 			return fmt.Errorf("model_rules[%d]: invalid selection_policy: %s", i, rule.SelectionPolicy)
 		}
+		if strings.EqualFold(rule.SelectionPolicy, "cheapest") {
+			for _, model := range rule.TargetModels {
+				if strings.Contains(model, "$") {
+					continue // Only known once a request matches
+				}
+				if _, ok := c.CatalogueEntry(catalogue, model); !ok {
+					return fmt.Errorf("model_rules[%d]: target model %s is not in the model catalogue", i, model)
+				}
+			}
+		}
+		if rule.HedgeDelayMs < 0 {
+			return fmt.Errorf("model_rules[%d]: hedge_delay_ms must not be negative", i)
+		}
+		if err := rule.Timeouts.validate(); err != nil {
+			return fmt.Errorf("model_rules[%d]: %w", i, err)
+		}
+		if err := rule.validateWeights(); err != nil {
+			return fmt.Errorf("model_rules[%d]: %w", i, err)
+		}
+		if err := rule.Shadow.validate(); err != nil {
+			return fmt.Errorf("model_rules[%d]: %w", i, err)
+		}
+		if err := rule.Cache.validate(); err != nil {
+			return fmt.Errorf("model_rules[%d]: %w", i, err)
+		}
 	}
 
 	return nil
 }
 
+// ProviderNames returns the names of the providers Roxy can route to.
+func ProviderNames() []string {
+	return []string{"openai", "anthropic", "openrouter", "chutes"}
+}
+
 func isValidProvider(provider string) bool {
-	validProviders := map[string]bool{
-		"openai":     true,
-		"anthropic":  true,
-		"openrouter": true,
-		"chutes":     true,
+	for _, name := range ProviderNames() {
+		if strings.ToLower(provider) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isValidKeyStrategy(strategy string) bool {
+	validStrategies := map[string]bool{
+		"round_robin":  true,
+		"lru":          true,
+		"least_loaded": true,
+		"weighted":     true,
+	}
+	return validStrategies[strings.ToLower(strategy)]
+}
+
+// RetryConditions returns the failures retry_on accepts.
+func RetryConditions() []string {
+	return []string{"rate_limited", "overloaded", "server_error", "timeout", "connection_error", "auth"}
+}
+
+func isValidRetryCondition(cond string) bool {
+	for _, name := range RetryConditions() {
+		if strings.ToLower(cond) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Capabilities returns the model capabilities the catalogue can list.
+func Capabilities() []string {
+	return []string{"tools", "vision", "json_mode"}
+}
+
+func isValidCapability(capability string) bool {
+	for _, name := range Capabilities() {
+		if strings.ToLower(capability) == name {
+			return true
+		}
 	}
-	return validProviders[strings.ToLower(provider)]
+	return false
 }
 
 func isValidSelectionPolicy(policy string) bool {
@@ -147,6 +596,10 @@ func isValidSelectionPolicy(policy string) bool {
 		"random":     true,
 		"roundrobin": true,
 		"fallback":   true,
+		"hedged":     true,
+		"adaptive":   true,
+		"cheapest":   true,
+		"weighted":   true,
 	}
 	return validPolicies[strings.ToLower(policy)]
 }
@@ -118,6 +118,363 @@ api_keys:
     max_tpm: 90000`,
 			expectedErr: true,
 		},
+		{
+			name: "key strategy",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+    weight: 3
+providers:
+  openai:
+    base_url: "https://api.openai.com/v1"
+    key_strategy: "weighted"`,
+			expectedErr: false,
+		},
+		{
+			name: "invalid key strategy",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+providers:
+  openai:
+    base_url: "https://api.openai.com/v1"
+    key_strategy: "fastest"`,
+			expectedErr: true,
+		},
+		{
+			name: "cheapest policy",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+models:
+  - name: "gpt-4o"
+    input_price: 0.0000025
+    output_price: 0.00001
+    context_window: 128000
+    capabilities: ["tools", "vision", "json_mode"]
+  - name: "gpt-4o-mini"
+    input_price: 0.00000015
+    output_price: 0.0000006
+    context_window: 128000
+    capabilities: ["tools", "json_mode"]
+model_rules:
+  - source_model: "gpt-4"
+    target_models: ["gpt-4o", "gpt-4o-mini"]
+    selection_policy: "cheapest"`,
+			expectedErr: false,
+		},
+		{
+			name: "cheapest target missing from catalogue",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+models:
+  - name: "gpt-4o"
+    input_price: 0.0000025
+    output_price: 0.00001
+model_rules:
+  - source_model: "gpt-4"
+    target_models: ["gpt-4o", "gpt-4o-mini"]
+    selection_policy: "cheapest"`,
+			expectedErr: true,
+		},
+		{
+			name: "invalid capability",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+models:
+  - name: "gpt-4o"
+    capabilities: ["telepathy"]`,
+			expectedErr: true,
+		},
+		{
+			name: "weighted policy",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+model_rules:
+  - source_model: "gpt-4"
+    target_models: ["gpt-4o", "gpt-4.1"]
+    selection_policy: "weighted"
+    weights:
+      gpt-4o: 90
+      gpt-4.1: 10
+    sticky_by: "header:X-User-ID"`,
+			expectedErr: false,
+		},
+		{
+			name: "weight for unknown target",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+model_rules:
+  - source_model: "gpt-4"
+    target_models: ["gpt-4o"]
+    selection_policy: "weighted"
+    weights:
+      gpt-4.1: 10`,
+			expectedErr: true,
+		},
+		{
+			name: "invalid sticky_by",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+model_rules:
+  - source_model: "gpt-4"
+    target_models: ["gpt-4o"]
+    selection_policy: "weighted"
+    sticky_by: "cookie"`,
+			expectedErr: true,
+		},
+		{
+			name: "shadow traffic",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+model_rules:
+  - source_model: "gpt-4"
+    target_models: ["gpt-4o"]
+    selection_policy: "fallback"
+    shadow:
+      models: ["gpt-4.1"]
+      sample_rate: 0.05
+      output: "shadow.jsonl"`,
+			expectedErr: false,
+		},
+		{
+			name: "shadow without output",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+model_rules:
+  - source_model: "gpt-4"
+    target_models: ["gpt-4o"]
+    selection_policy: "fallback"
+    shadow:
+      models: ["gpt-4.1"]
+      sample_rate: 0.05`,
+			expectedErr: true,
+		},
+		{
+			name: "shadow sample rate out of range",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+model_rules:
+  - source_model: "gpt-4"
+    target_models: ["gpt-4o"]
+    selection_policy: "fallback"
+    shadow:
+      models: ["gpt-4.1"]
+      sample_rate: 5
+      output: "shadow.jsonl"`,
+			expectedErr: true,
+		},
+		{
+			name: "explicit and mapped providers",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+model_providers:
+  mistral-*: "openrouter"
+model_rules:
+  - source_model: "gpt-4"
+    target_models:
+      - provider: "anthropic"
+        model: "claude-3-5-sonnet"
+      - "mistral-large"
+    selection_policy: "fallback"`,
+			expectedErr: false,
+		},
+		{
+			name: "target with no provider",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+model_rules:
+  - source_model: "gpt-4"
+    target_models: ["mistral-large"]
+    selection_policy: "fallback"`,
+			expectedErr: true,
+		},
+		{
+			name: "invalid mapped provider",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+model_providers:
+  mistral-large: "mistral"`,
+			expectedErr: true,
+		},
+		{
+			name: "cache limits",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+cache:
+  ttl_sec: 600
+  max_entries: 5000
+  max_bytes: 33554432
+  eviction: "lfu"`,
+			expectedErr: false,
+		},
+		{
+			name: "invalid cache eviction",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+cache:
+  eviction: "fifo"`,
+			expectedErr: true,
+		},
+		{
+			name: "redis cache backend",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+cache:
+  backend: "redis"
+  ttl_sec: 600
+  redis:
+    addr: "localhost:6379"
+    db: 2`,
+			expectedErr: false,
+		},
+		{
+			name: "disk cache backend without path",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+cache:
+  backend: "disk"`,
+			expectedErr: true,
+		},
+		{
+			name: "invalid cache backend",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+cache:
+  backend: "memcached"`,
+			expectedErr: true,
+		},
+		{
+			name: "cache namespace and key model",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+cache:
+  namespace_by: "header:X-Tenant"
+  key_model: "target"`,
+			expectedErr: false,
+		},
+		{
+			name: "rule cache policy",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+model_rules:
+  - source_model: "gpt-4"
+    target_models: ["gpt-4o"]
+    selection_policy: "random"
+    cache:
+      mode: "deterministic"
+      ttl_sec: 3600`,
+			expectedErr: false,
+		},
+		{
+			name: "invalid rule cache mode",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+model_rules:
+  - source_model: "gpt-4"
+    target_models: ["gpt-4o"]
+    selection_policy: "random"
+    cache:
+      mode: "sometimes"`,
+			expectedErr: true,
+		},
+		{
+			name: "invalid cache key model",
+			config: `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+cache:
+  key_model: "provider"`,
+			expectedErr: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -138,3 +495,60 @@ api_keys:
 		})
 	}
 }
+
+func TestModelCatalogueFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	cataloguePath := filepath.Join(tmpDir, "models.yaml")
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	catalogueContent := `models:
+  - name: "gpt-4o"
+    input_price: 0.0000025
+    output_price: 0.00001
+    context_window: 128000
+  - name: "claude-3-haiku"
+    input_price: 0.00000025
+    output_price: 0.00000125
+    context_window: 200000`
+
+	configContent := `listen_addr: ":8080"
+api_keys:
+  - key: "test-key"
+    provider: "openai"
+    max_rpm: 3500
+    max_tpm: 90000
+model_catalogue: "` + cataloguePath + `"
+models:
+  - name: "gpt-4o"
+    input_price: 0.000002
+    output_price: 0.000008
+    context_window: 128000
+model_rules:
+  - source_model: "gpt-4"
+    target_models: ["gpt-4o", "claude-3-haiku"]
+    selection_policy: "cheapest"`
+
+	if err := os.WriteFile(cataloguePath, []byte(catalogueContent), 0644); err != nil {
+		t.Fatalf("Failed to create test catalogue file: %v", err)
+	}
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	catalogue := cfg.Catalogue()
+	if len(catalogue) != 2 {
+		t.Fatalf("Expected 2 catalogue models, got %d", len(catalogue))
+	}
+	if catalogue["claude-3-haiku"].ContextWindow != 200000 {
+		t.Errorf("Expected claude-3-haiku context window 200000, got %d", catalogue["claude-3-haiku"].ContextWindow)
+	}
+	// The config's own entry replaces the file's
+	if catalogue["gpt-4o"].InputPrice != 0.000002 {
+		t.Errorf("Expected gpt-4o input price 0.000002, got %g", catalogue["gpt-4o"].InputPrice)
+	}
+}
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// regexPrefix marks a source_model as a regular expression rather than a
+// model name or glob.
+const regexPrefix = "re:"
+
+var (
+	patternsMu sync.Mutex
+	patterns   = make(map[string]*regexp.Regexp)
+)
+
+// IsPattern reports whether source is a glob or regular expression rather
+// than a plain model name.
+func IsPattern(source string) bool {
+	return strings.HasPrefix(source, regexPrefix) || strings.ContainsAny(source, "*?")
+}
+
+// compilePattern returns the expression matching the model names source
+// stands for. Regular expressions must match the whole name. In globs,
+// * matches any run of characters and ? any one character, and each is a
+// capture group.
+func compilePattern(source string) (*regexp.Regexp, error) {
+	patternsMu.Lock()
+	defer patternsMu.Unlock()
+
+	if re, ok := patterns[source]; ok {
+		return re, nil
+	}
+
+	var expr string
+	if rest, ok := strings.CutPrefix(source, regexPrefix); ok {
+		expr = "^(?:" + rest + ")$"
+	} else {
+		var b strings.Builder
+		b.WriteString("^")
+		for _, c := range source {
+			switch c {
+			case '*':
+				b.WriteString("(.*)")
+			case '?':
+				b.WriteString("(.)")
+			default:
+				b.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		b.WriteString("$")
+		expr = b.String()
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	patterns[source] = re
+	return re, nil
+}
+
+// Match reports whether model is covered by the rule's source_model. For
+// a pattern the returned rule has the match's capture groups ($1, ${name})
+// substituted into its target models, weights and shadow models.
+func (r ModelRule) Match(model string) (ModelRule, bool) {
+	if !IsPattern(r.SourceModel) {
+		return r, r.SourceModel == model
+	}
+
+	re, err := compilePattern(r.SourceModel)
+	if err != nil {
+		return r, false
+	}
+	match := re.FindStringSubmatchIndex(model)
+	if match == nil {
+		return r, false
+	}
+
+	expand := func(template string) string {
+		if !strings.Contains(template, "$") {
+			return template
+		}
+		return string(re.ExpandString(nil, template, model, match))
+	}
+
+	resolved := r
+	resolved.TargetModels = make([]string, len(r.TargetModels))
+	for i, target := range r.TargetModels {
+		resolved.TargetModels[i] = expand(target)
+	}
+	if r.Weights != nil {
+		resolved.Weights = make(map[string]int, len(r.Weights))
+		for target, weight := range r.Weights {
+			resolved.Weights[expand(target)] = weight
+		}
+	}
+	if r.Shadow.Models != nil {
+		resolved.Shadow.Models = make([]string, len(r.Shadow.Models))
+		for i, target := range r.Shadow.Models {
+			resolved.Shadow.Models[i] = expand(target)
+		}
+	}
+	return resolved, true
+}
+
+// MatchRule returns the model rule for model, or nil if there is none. A
+// rule naming the model exactly takes precedence; otherwise the first rule
+// whose pattern matches applies.
+func (c *Config) MatchRule(model string) *ModelRule {
+	for i := range c.ModelRules {
+		rule := &c.ModelRules[i]
+		if !IsPattern(rule.SourceModel) && rule.SourceModel == model {
+			return rule
+		}
+	}
+	for _, rule := range c.ModelRules {
+		if !IsPattern(rule.SourceModel) {
+			continue
+		}
+		if resolved, ok := rule.Match(model); ok {
+			return &resolved
+		}
+	}
+	return nil
+}
+
+var groupRef = regexp.MustCompile(`\$(?:\{(\w+)\}|(\w+))`)
+
+// validatePattern checks that a pattern source_model compiles and that
+// the capture groups its targets refer to exist.
+func (r *ModelRule) validatePattern() error {
+	if !IsPattern(r.SourceModel) {
+		return nil
+	}
+	re, err := compilePattern(r.SourceModel)
+	if err != nil {
+		return fmt.Errorf("invalid source_model pattern %s: %w", r.SourceModel, err)
+	}
+
+	targets := append(append([]string{}, r.TargetModels...), r.Shadow.Models...)
+	for _, target := range targets {
+		for _, ref := range groupRef.FindAllStringSubmatch(target, -1) {
+			name := ref[1] + ref[2]
+			if n, err := strconv.Atoi(name); err == nil {
+				if n > re.NumSubexp() {
+					return fmt.Errorf("target %s refers to missing group $%d", target, n)
+				}
+			} else if re.SubexpIndex(name) < 0 {
+				return fmt.Errorf("target %s refers to missing group %s", target, name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchRule(t *testing.T) {
+	cfg := &Config{
+		ModelRules: []ModelRule{
+			{SourceModel: "gpt-4*", TargetModels: []string{"gpt-4o"}},
+			{SourceModel: "gpt-4-turbo", TargetModels: []string{"gpt-4.1"}},
+			{SourceModel: `re:^claude-3-5-(\w+)-(?P<date>\d+)$`, TargetModels: []string{"claude-3-7-$1-${date}", "openrouter/anthropic/claude-3.5-${1}"}},
+			{SourceModel: "gemini-?.?-*", TargetModels: []string{"openrouter/google/gemini-$1.$2-$3"}},
+			{SourceModel: "re:claude-.*", TargetModels: []string{"claude-3-haiku"}},
+		},
+	}
+
+	testCases := []struct {
+		name            string
+		model           string
+		expectedSource  string
+		expectedTargets TargetList
+	}{
+		{
+			name:            "exact match beats an earlier pattern",
+			model:           "gpt-4-turbo",
+			expectedSource:  "gpt-4-turbo",
+			expectedTargets: TargetList{"gpt-4.1"},
+		},
+		{
+			name:            "glob",
+			model:           "gpt-4-0613",
+			expectedSource:  "gpt-4*",
+			expectedTargets: TargetList{"gpt-4o"},
+		},
+		{
+			name:            "regex with numbered and named groups",
+			model:           "claude-3-5-sonnet-20240620",
+			expectedSource:  `re:^claude-3-5-(\w+)-(?P<date>\d+)$`,
+			expectedTargets: TargetList{"claude-3-7-sonnet-20240620", "openrouter/anthropic/claude-3.5-sonnet"},
+		},
+		{
+			name:            "glob wildcards are groups",
+			model:           "gemini-1.5-pro",
+			expectedSource:  "gemini-?.?-*",
+			expectedTargets: TargetList{"openrouter/google/gemini-1.5-pro"},
+		},
+		{
+			name:            "first matching pattern wins",
+			model:           "claude-3-5-sonnet-latest",
+			expectedSource:  "re:claude-.*",
+			expectedTargets: TargetList{"claude-3-haiku"},
+		},
+		{
+			name:            "regex must match the whole name",
+			model:           "my-claude-3",
+			expectedSource:  "",
+			expectedTargets: nil,
+		},
+		{
+			name:            "no match",
+			model:           "llama-3",
+			expectedSource:  "",
+			expectedTargets: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule := cfg.MatchRule(tc.model)
+			if tc.expectedSource == "" {
+				if rule != nil {
+					t.Fatalf("Expected no rule, got %s", rule.SourceModel)
+				}
+				return
+			}
+			if rule == nil {
+				t.Fatalf("Expected rule %s, got none", tc.expectedSource)
+			}
+			if rule.SourceModel != tc.expectedSource {
+				t.Errorf("Expected rule %s, got %s", tc.expectedSource, rule.SourceModel)
+			}
+			if !reflect.DeepEqual(rule.TargetModels, tc.expectedTargets) {
+				t.Errorf("Expected targets %v, got %v", tc.expectedTargets, rule.TargetModels)
+			}
+		})
+	}
+
+	// Substitution leaves the configured rule untouched
+	if cfg.ModelRules[2].TargetModels[0] != "claude-3-7-$1-${date}" {
+		t.Errorf("Expected the configured targets to be unchanged, got %v", cfg.ModelRules[2].TargetModels)
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	testCases := []struct {
+		name        string
+		rule        ModelRule
+		expectedErr bool
+	}{
+		{
+			name: "valid regex",
+			rule: ModelRule{SourceModel: `re:^gpt-4-(\d+)$`, TargetModels: []string{"gpt-4o-$1"}},
+		},
+		{
+			name:        "regex does not compile",
+			rule:        ModelRule{SourceModel: "re:^gpt-4-(", TargetModels: []string{"gpt-4o"}},
+			expectedErr: true,
+		},
+		{
+			name:        "missing numbered group",
+			rule:        ModelRule{SourceModel: "gpt-4-*", TargetModels: []string{"gpt-4o-$2"}},
+			expectedErr: true,
+		},
+		{
+			name:        "missing named group",
+			rule:        ModelRule{SourceModel: `re:gpt-4-(?P<date>\d+)`, TargetModels: []string{"gpt-4o-${version}"}},
+			expectedErr: true,
+		},
+		{
+			name:        "missing group in shadow model",
+			rule:        ModelRule{SourceModel: "gpt-4-*", TargetModels: []string{"gpt-4o"}, Shadow: ShadowConfig{Models: []string{"gpt-4.1-$3"}}},
+			expectedErr: true,
+		},
+		{
+			name: "plain model name",
+			rule: ModelRule{SourceModel: "gpt-4", TargetModels: []string{"gpt-4o"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.rule.validatePattern()
+			if tc.expectedErr && err == nil {
+				t.Error("Expected error but got none")
+			} else if !tc.expectedErr && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
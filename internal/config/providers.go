@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TargetList is a list of target models. In YAML each entry is either a
+// model name or a mapping naming the provider explicitly:
+//
+//	target_models:
+//	  - "gpt-4o"
+//	  - provider: "anthropic"
+//	    model: "claude-3-5-sonnet"
+//
+// An explicit provider is kept as a "provider/" prefix on the model, the
+// same form the openrouter/ and chutes/ prefixes have always used.
+type TargetList []string
+
+func (l *TargetList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode {
+		return fmt.Errorf("line %d: target models must be a list", node.Line)
+	}
+
+	targets := make(TargetList, 0, len(node.Content))
+	for _, item := range node.Content {
+		switch item.Kind {
+		case yaml.ScalarNode:
+			targets = append(targets, item.Value)
+		case yaml.MappingNode:
+			var target struct {
+				Provider string `yaml:"provider"`
+				Model    string `yaml:"model"`
+			}
+			if err := item.Decode(&target); err != nil {
+				return err
+			}
+			if target.Model == "" {
+				return fmt.Errorf("line %d: target model is required", item.Line)
+			}
+			if target.Provider == "" {
+				targets = append(targets, target.Model)
+			} else {
+				targets = append(targets, strings.ToLower(target.Provider)+"/"+target.Model)
+			}
+		default:
+			return fmt.Errorf("line %d: invalid target model", item.Line)
+		}
+	}
+	*l = targets
+	return nil
+}
+
+// builtinProviders are the model name prefixes whose provider is known
+// without any configuration.
+var builtinProviders = []struct {
+	prefix   string
+	provider string
+}{
+	{"gpt-", "openai"},
+	{"claude-", "anthropic"},
+}
+
+// ProviderFor returns the provider that serves model and the model name to
+// send it. The provider is, in order of precedence:
+//
+//   - named by a "provider/" prefix on the model, such as "anthropic/claude-3-5-sonnet"
+//     or "openrouter/meta-llama/llama-3-70b"
+//   - mapped to the model in model_providers, by exact name and then by the
+//     longest matching glob, the first in byte order if several are as long
+//   - known from a gpt- or claude- prefix
+//
+// ok is false if none of these apply.
+func (c *Config) ProviderFor(model string) (provider, name string, ok bool) {
+	if prefix, rest, found := strings.Cut(model, "/"); found && isValidProvider(prefix) && rest != "" {
+		return strings.ToLower(prefix), rest, true
+	}
+
+	if provider, found := c.ModelProviders[model]; found {
+		return strings.ToLower(provider), model, true
+	}
+	best := ""
+	for pattern, provider := range c.ModelProviders {
+		if !IsPattern(pattern) || len(pattern) < len(best) || (len(pattern) == len(best) && pattern >= best) {
+			continue
+		}
+		if re, err := compilePattern(pattern); err == nil && re.MatchString(model) {
+			best = pattern
+			name = strings.ToLower(provider)
+		}
+	}
+	if best != "" {
+		return name, model, true
+	}
+
+	for _, builtin := range builtinProviders {
+		if strings.HasPrefix(model, builtin.prefix) {
+			return builtin.provider, model, true
+		}
+	}
+	return "", model, false
+}
+
+func (c *Config) validateModelProviders() error {
+	for model, provider := range c.ModelProviders {
+		if !isValidProvider(provider) {
+			return fmt.Errorf("model_providers: invalid provider %s for %s", provider, model)
+		}
+		if IsPattern(model) {
+			if _, err := compilePattern(model); err != nil {
+				return fmt.Errorf("model_providers: invalid pattern %s: %w", model, err)
+			}
+		}
+	}
+	return nil
+}
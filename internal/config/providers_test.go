@@ -0,0 +1,112 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestProviderFor(t *testing.T) {
+	cfg := &Config{
+		ModelProviders: map[string]string{
+			"mistral-large":              "openrouter",
+			"o1-*":                       "openai",
+			"text-embedding-*":           "openai",
+			"llama-*":                    "openrouter",
+			"llama-3-*-instruct":         "chutes",
+			"gpt-4o-selfhosted":          "chutes",
+			"claude-3-5-sonnet-rerouted": "OpenRouter",
+			"qwen-*":                     "openrouter",
+			"*-plus":                     "chutes",
+		},
+	}
+
+	testCases := []struct {
+		model            string
+		expectedProvider string
+		expectedName     string
+		expectedOk       bool
+	}{
+		{model: "anthropic/claude-3-5-sonnet", expectedProvider: "anthropic", expectedName: "claude-3-5-sonnet", expectedOk: true},
+		{model: "openrouter/meta-llama/llama-3-70b", expectedProvider: "openrouter", expectedName: "meta-llama/llama-3-70b", expectedOk: true},
+		{model: "meta-llama/llama-3-70b", expectedProvider: "", expectedName: "meta-llama/llama-3-70b", expectedOk: false},
+		{model: "mistral-large", expectedProvider: "openrouter", expectedName: "mistral-large", expectedOk: true},
+		{model: "o1-mini", expectedProvider: "openai", expectedName: "o1-mini", expectedOk: true},
+		{model: "text-embedding-3-small", expectedProvider: "openai", expectedName: "text-embedding-3-small", expectedOk: true},
+		{model: "llama-3-70b-instruct", expectedProvider: "chutes", expectedName: "llama-3-70b-instruct", expectedOk: true},
+		{model: "llama-3-70b", expectedProvider: "openrouter", expectedName: "llama-3-70b", expectedOk: true},
+		{model: "gpt-4o-selfhosted", expectedProvider: "chutes", expectedName: "gpt-4o-selfhosted", expectedOk: true},
+		{model: "claude-3-5-sonnet-rerouted", expectedProvider: "openrouter", expectedName: "claude-3-5-sonnet-rerouted", expectedOk: true},
+		{model: "gpt-4o", expectedProvider: "openai", expectedName: "gpt-4o", expectedOk: true},
+		{model: "claude-3-haiku", expectedProvider: "anthropic", expectedName: "claude-3-haiku", expectedOk: true},
+		{model: "mistral-small", expectedProvider: "", expectedName: "mistral-small", expectedOk: false},
+		// Globs as long as each other are tried in byte order
+		{model: "qwen-plus", expectedProvider: "chutes", expectedName: "qwen-plus", expectedOk: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.model, func(t *testing.T) {
+			provider, name, ok := cfg.ProviderFor(tc.model)
+			if provider != tc.expectedProvider || name != tc.expectedName || ok != tc.expectedOk {
+				t.Errorf("Expected (%q, %q, %v), got (%q, %q, %v)",
+					tc.expectedProvider, tc.expectedName, tc.expectedOk, provider, name, ok)
+			}
+		})
+	}
+}
+
+func TestTargetListYAML(t *testing.T) {
+	testCases := []struct {
+		name        string
+		yaml        string
+		expected    TargetList
+		expectedErr bool
+	}{
+		{
+			name:     "model names",
+			yaml:     `["gpt-4o", "claude-3-haiku"]`,
+			expected: TargetList{"gpt-4o", "claude-3-haiku"},
+		},
+		{
+			name: "explicit providers",
+			yaml: `
+- "gpt-4o"
+- provider: "Anthropic"
+  model: "claude-3-5-sonnet"
+- provider: "openrouter"
+  model: "mistralai/mistral-large"
+- model: "o1-mini"`,
+			expected: TargetList{"gpt-4o", "anthropic/claude-3-5-sonnet", "openrouter/mistralai/mistral-large", "o1-mini"},
+		},
+		{
+			name:        "missing model",
+			yaml:        `[{provider: "anthropic"}]`,
+			expectedErr: true,
+		},
+		{
+			name:        "not a list",
+			yaml:        `"gpt-4o"`,
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var targets TargetList
+			err := yaml.Unmarshal([]byte(tc.yaml), &targets)
+			if tc.expectedErr {
+				if err == nil {
+					t.Error("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(targets, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, targets)
+			}
+		})
+	}
+}
@@ -0,0 +1,425 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CiaranMcAleer/roxy/internal/config"
+)
+
+func init() {
+	Register("anthropic", func(cfg *config.Config) Provider {
+		return &Anthropic{baseURL: cfg.Providers.Anthropic.BaseURL}
+	})
+}
+
+const (
+	// anthropicVersion is the Messages API version requests are written for.
+	anthropicVersion = "2023-06-01"
+
+	// anthropicDefaultMaxTokens is used when the client doesn't set
+	// max_tokens, which the Messages API requires.
+	anthropicDefaultMaxTokens = 4096
+)
+
+// StatusOverloaded is the non-standard status Anthropic returns when its
+// API is temporarily overloaded.
+const StatusOverloaded = 529
+
+// Anthropic translates between OpenAI chat completions and the Anthropic
+// Messages API in both directions, including streamed responses.
+type Anthropic struct {
+	baseURL string
+}
+
+func (p *Anthropic) Name() string {
+	return "anthropic"
+}
+
+func (p *Anthropic) URL() string {
+	return p.baseURL + "/messages"
+}
+
+func (p *Anthropic) Authenticate(req *http.Request, key string) {
+	req.Header.Set("X-Api-Key", key)
+	if req.Header.Get("Anthropic-Version") == "" {
+		req.Header.Set("Anthropic-Version", anthropicVersion)
+	}
+}
+
+func (p *Anthropic) TranslateRequest(body []byte, model string) ([]byte, error) {
+	var in chatRequest
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, err
+	}
+
+	out, err := toAnthropicRequest(&in)
+	if err != nil {
+		return nil, err
+	}
+	out.Model = model
+
+	return marshalDocument(out)
+}
+
+func (p *Anthropic) TranslateResponse(body []byte) ([]byte, error) {
+	var in anthropicResponse
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, err
+	}
+	return marshalDocument(fromAnthropicResponse(&in))
+}
+
+func (p *Anthropic) TranslateStream(body []byte) StreamTranslator {
+	return &anthropicStreamTranslator{created: time.Now().Unix()}
+}
+
+func (p *Anthropic) ParseUsage(body []byte) Usage {
+	var resp anthropicResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return Usage{}
+	}
+	return resp.Usage.usage()
+}
+
+func (p *Anthropic) ClassifyError(status int, body []byte) ErrorClass {
+	if status == StatusOverloaded {
+		return ErrOverloaded
+	}
+	return classifyStatus(status)
+}
+
+// Messages API wire types
+
+type anthropicRequest struct {
+	Model         string               `json:"model"`
+	System        string               `json:"system,omitempty"`
+	Messages      []anthropicMessage   `json:"messages"`
+	MaxTokens     int                  `json:"max_tokens"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	TopP          *float64             `json:"top_p,omitempty"`
+	StopSequences []string             `json:"stop_sequences,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	Tools         []anthropicTool      `json:"tools,omitempty"`
+	ToolChoice    *anthropicToolChoice `json:"tool_choice,omitempty"`
+	Metadata      *anthropicMetadata   `json:"metadata,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicBlock struct {
+	Type      string                `json:"type"`
+	Text      string                `json:"text,omitempty"`
+	Source    *anthropicImageSource `json:"source,omitempty"`
+	ID        string                `json:"id,omitempty"`
+	Name      string                `json:"name,omitempty"`
+	Input     json.RawMessage       `json:"input,omitempty"`
+	ToolUseID string                `json:"tool_use_id,omitempty"`
+	Content   json.RawMessage       `json:"content,omitempty"`
+	IsError   bool                  `json:"is_error,omitempty"`
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicToolChoice struct {
+	Type                   string `json:"type"`
+	Name                   string `json:"name,omitempty"`
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
+}
+
+type anthropicMetadata struct {
+	UserID string `json:"user_id,omitempty"`
+}
+
+type anthropicResponse struct {
+	ID           string           `json:"id"`
+	Type         string           `json:"type"`
+	Role         string           `json:"role"`
+	Model        string           `json:"model"`
+	Content      []anthropicBlock `json:"content"`
+	StopReason   string           `json:"stop_reason"`
+	StopSequence *string          `json:"stop_sequence"`
+	Usage        anthropicUsage   `json:"usage"`
+}
+
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+}
+
+// usage counts cache reads and writes as prompt tokens, matching how
+// OpenAI reports cached prompt tokens.
+func (u anthropicUsage) usage() Usage {
+	return Usage{
+		PromptTokens:     u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens,
+		CompletionTokens: u.OutputTokens,
+	}
+}
+
+func toAnthropicRequest(in *chatRequest) (*anthropicRequest, error) {
+	out := &anthropicRequest{
+		Model:         in.Model,
+		MaxTokens:     anthropicDefaultMaxTokens,
+		TopP:          in.TopP,
+		StopSequences: stringList(in.Stop),
+		Stream:        in.Stream,
+	}
+
+	switch {
+	case in.MaxCompletionTokens != nil:
+		out.MaxTokens = *in.MaxCompletionTokens
+	case in.MaxTokens != nil:
+		out.MaxTokens = *in.MaxTokens
+	}
+
+	// OpenAI accepts temperatures up to 2, Anthropic only up to 1
+	if in.Temperature != nil {
+		t := *in.Temperature
+		if t > 1 {
+			t = 1
+		}
+		out.Temperature = &t
+	}
+
+	if in.User != "" {
+		out.Metadata = &anthropicMetadata{UserID: in.User}
+	}
+
+	messages := in.Messages
+	if len(messages) == 0 && len(in.Prompt) > 0 {
+		messages = []chatMessage{{Role: "user", Content: in.Prompt}}
+	}
+
+	var system []string
+	for _, msg := range messages {
+		switch msg.Role {
+		case "system", "developer":
+			system = append(system, contentText(msg.Content))
+		case "user":
+			out.appendBlocks("user", userBlocks(msg.Content))
+		case "assistant":
+			blocks, err := assistantBlocks(&msg)
+			if err != nil {
+				return nil, err
+			}
+			out.appendBlocks("assistant", blocks)
+		case "tool", "function":
+			out.appendBlocks("user", []anthropicBlock{toolResultBlock(&msg)})
+		default:
+			return nil, fmt.Errorf("unsupported message role %q", msg.Role)
+		}
+	}
+	out.System = strings.Join(system, "\n\n")
+
+	for _, tool := range in.Tools {
+		if tool.Type != "function" {
+			continue
+		}
+		schema := tool.Function.Parameters
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{"type":"object","properties":{}}`)
+		}
+		out.Tools = append(out.Tools, anthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: schema,
+		})
+	}
+
+	out.ToolChoice = toAnthropicToolChoice(in.ToolChoice)
+	if in.ParallelToolCalls != nil && !*in.ParallelToolCalls && len(out.Tools) > 0 {
+		if out.ToolChoice == nil {
+			out.ToolChoice = &anthropicToolChoice{Type: "auto"}
+		}
+		out.ToolChoice.DisableParallelToolUse = true
+	}
+	if out.ToolChoice != nil && out.ToolChoice.Type == "none" {
+		// Anthropic has no "none" choice; not offering tools is equivalent
+		out.Tools = nil
+		out.ToolChoice = nil
+	}
+
+	return out, nil
+}
+
+// appendBlocks adds blocks as a turn for role, merging with the previous
+// turn when it has the same role since the Messages API requires turns to
+// alternate.
+func (r *anthropicRequest) appendBlocks(role string, blocks []anthropicBlock) {
+	if len(blocks) == 0 {
+		return
+	}
+	if n := len(r.Messages); n > 0 && r.Messages[n-1].Role == role {
+		r.Messages[n-1].Content = append(r.Messages[n-1].Content, blocks...)
+		return
+	}
+	r.Messages = append(r.Messages, anthropicMessage{Role: role, Content: blocks})
+}
+
+func userBlocks(content json.RawMessage) []anthropicBlock {
+	var blocks []anthropicBlock
+	for _, part := range contentParts(content) {
+		switch part.Type {
+		case "text":
+			if part.Text != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: part.Text})
+			}
+		case "image_url":
+			if part.ImageURL != nil {
+				blocks = append(blocks, anthropicBlock{Type: "image", Source: imageSource(part.ImageURL.URL)})
+			}
+		}
+	}
+	return blocks
+}
+
+// imageSource converts an OpenAI image URL, which may be a data URL, into
+// an Anthropic image source.
+func imageSource(url string) *anthropicImageSource {
+	if rest, ok := strings.CutPrefix(url, "data:"); ok {
+		meta, data, _ := strings.Cut(rest, ",")
+		mediaType, _, _ := strings.Cut(meta, ";")
+		return &anthropicImageSource{Type: "base64", MediaType: mediaType, Data: data}
+	}
+	return &anthropicImageSource{Type: "url", URL: url}
+}
+
+func assistantBlocks(msg *chatMessage) ([]anthropicBlock, error) {
+	var blocks []anthropicBlock
+	if text := contentText(msg.Content); text != "" {
+		blocks = append(blocks, anthropicBlock{Type: "text", Text: text})
+	}
+
+	for _, call := range msg.ToolCalls {
+		input := json.RawMessage(call.Function.Arguments)
+		if strings.TrimSpace(call.Function.Arguments) == "" {
+			input = json.RawMessage("{}")
+		}
+		if !json.Valid(input) {
+			return nil, fmt.Errorf("tool call %s has invalid JSON arguments", call.ID)
+		}
+		blocks = append(blocks, anthropicBlock{
+			Type:  "tool_use",
+			ID:    call.ID,
+			Name:  call.Function.Name,
+			Input: input,
+		})
+	}
+	return blocks, nil
+}
+
+func toolResultBlock(msg *chatMessage) anthropicBlock {
+	id := msg.ToolCallID
+	if id == "" {
+		id = msg.Name
+	}
+	content, _ := json.Marshal(contentText(msg.Content))
+	return anthropicBlock{Type: "tool_result", ToolUseID: id, Content: content}
+}
+
+func toAnthropicToolChoice(raw json.RawMessage) *anthropicToolChoice {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+
+	var mode string
+	if err := json.Unmarshal(raw, &mode); err == nil {
+		switch mode {
+		case "auto":
+			return &anthropicToolChoice{Type: "auto"}
+		case "required":
+			return &anthropicToolChoice{Type: "any"}
+		case "none":
+			return &anthropicToolChoice{Type: "none"}
+		}
+		return nil
+	}
+
+	var named struct {
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(raw, &named); err == nil && named.Function.Name != "" {
+		return &anthropicToolChoice{Type: "tool", Name: named.Function.Name}
+	}
+	return nil
+}
+
+func fromAnthropicResponse(in *anthropicResponse) *chatResponse {
+	var text []string
+	var toolCalls []chatToolCall
+	for _, block := range in.Content {
+		switch block.Type {
+		case "text":
+			text = append(text, block.Text)
+		case "tool_use":
+			var args bytes.Buffer
+			if err := json.Compact(&args, block.Input); err != nil {
+				args.WriteString("{}")
+			}
+			toolCalls = append(toolCalls, chatToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: chatFunction{Name: block.Name, Arguments: args.String()},
+			})
+		}
+	}
+
+	content := json.RawMessage("null")
+	if len(text) > 0 || len(toolCalls) == 0 {
+		content, _ = json.Marshal(strings.Join(text, ""))
+	}
+
+	return &chatResponse{
+		ID:      in.ID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   in.Model,
+		Choices: []chatChoice{
+			{
+				Message: &chatMessage{
+					Role:      "assistant",
+					Content:   content,
+					ToolCalls: toolCalls,
+				},
+				FinishReason: stringPtr(finishReason(in.StopReason)),
+			},
+		},
+		Usage: newChatUsage(in.Usage.usage()),
+	}
+}
+
+// finishReason maps an Anthropic stop_reason onto OpenAI's finish_reason.
+func finishReason(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	default:
+		return "stop"
+	}
+}
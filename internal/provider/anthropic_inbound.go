@@ -0,0 +1,484 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/CiaranMcAleer/roxy/internal/sse"
+)
+
+// The functions in this file serve clients that speak the Anthropic Messages
+// API natively. Their requests are converted into the OpenAI shape used
+// inside Roxy, and the OpenAI-shaped results are converted back.
+
+type anthropicInboundRequest struct {
+	Model         string               `json:"model"`
+	System        json.RawMessage      `json:"system,omitempty"`
+	Messages      []anthropicInMessage `json:"messages"`
+	MaxTokens     *int                 `json:"max_tokens,omitempty"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	TopP          *float64             `json:"top_p,omitempty"`
+	StopSequences []string             `json:"stop_sequences,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	Tools         []anthropicTool      `json:"tools,omitempty"`
+	ToolChoice    *anthropicToolChoice `json:"tool_choice,omitempty"`
+	Metadata      *anthropicMetadata   `json:"metadata,omitempty"`
+}
+
+// anthropicInMessage accepts content either as a plain string or as an
+// array of blocks.
+type anthropicInMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// AnthropicToChatRequest converts a Messages API request body into an
+// OpenAI chat completions request body.
+func AnthropicToChatRequest(body []byte) ([]byte, error) {
+	var in anthropicInboundRequest
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, err
+	}
+
+	doc := map[string]interface{}{
+		"model": in.Model,
+	}
+	if in.MaxTokens != nil {
+		doc["max_tokens"] = *in.MaxTokens
+	}
+	if in.Temperature != nil {
+		doc["temperature"] = *in.Temperature
+	}
+	if in.TopP != nil {
+		doc["top_p"] = *in.TopP
+	}
+	if len(in.StopSequences) > 0 {
+		doc["stop"] = in.StopSequences
+	}
+	if in.Stream {
+		doc["stream"] = true
+		doc["stream_options"] = map[string]bool{"include_usage": true}
+	}
+	if in.Metadata != nil && in.Metadata.UserID != "" {
+		doc["user"] = in.Metadata.UserID
+	}
+
+	var messages []chatMessage
+	if system := blocksText(anthropicBlocks(in.System)); system != "" {
+		content, _ := json.Marshal(system)
+		messages = append(messages, chatMessage{Role: "system", Content: content})
+	}
+	for _, msg := range in.Messages {
+		messages = append(messages, chatMessages(msg)...)
+	}
+	doc["messages"] = messages
+
+	if len(in.Tools) > 0 {
+		tools := make([]chatTool, 0, len(in.Tools))
+		for _, tool := range in.Tools {
+			var t chatTool
+			t.Type = "function"
+			t.Function.Name = tool.Name
+			t.Function.Description = tool.Description
+			t.Function.Parameters = tool.InputSchema
+			tools = append(tools, t)
+		}
+		doc["tools"] = tools
+	}
+
+	if choice := in.ToolChoice; choice != nil {
+		switch choice.Type {
+		case "auto":
+			doc["tool_choice"] = "auto"
+		case "any":
+			doc["tool_choice"] = "required"
+		case "none":
+			doc["tool_choice"] = "none"
+		case "tool":
+			doc["tool_choice"] = map[string]interface{}{
+				"type":     "function",
+				"function": map[string]string{"name": choice.Name},
+			}
+		}
+		if choice.DisableParallelToolUse {
+			doc["parallel_tool_calls"] = false
+		}
+	}
+
+	return marshalDocument(doc)
+}
+
+// anthropicBlocks normalises Messages API content, which may be a plain
+// string or an array of blocks.
+func anthropicBlocks(content json.RawMessage) []anthropicBlock {
+	if len(content) == 0 || string(content) == "null" {
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return []anthropicBlock{{Type: "text", Text: text}}
+	}
+
+	var blocks []anthropicBlock
+	json.Unmarshal(content, &blocks)
+	return blocks
+}
+
+func blocksText(blocks []anthropicBlock) string {
+	var texts []string
+	for _, block := range blocks {
+		if block.Type == "text" {
+			texts = append(texts, block.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// chatMessages converts one Messages API turn into chat messages. Tool
+// results become separate tool messages, which OpenAI requires to directly
+// follow the assistant turn that made the calls.
+func chatMessages(msg anthropicInMessage) []chatMessage {
+	blocks := anthropicBlocks(msg.Content)
+
+	if msg.Role == "assistant" {
+		out := chatMessage{Role: "assistant"}
+		if text := blocksText(blocks); text != "" {
+			out.Content, _ = json.Marshal(text)
+		} else {
+			out.Content = json.RawMessage("null")
+		}
+		for _, block := range blocks {
+			if block.Type != "tool_use" {
+				continue
+			}
+			args := "{}"
+			if len(block.Input) > 0 {
+				var buf bytes.Buffer
+				if err := json.Compact(&buf, block.Input); err == nil {
+					args = buf.String()
+				}
+			}
+			out.ToolCalls = append(out.ToolCalls, chatToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: chatFunction{Name: block.Name, Arguments: args},
+			})
+		}
+		return []chatMessage{out}
+	}
+
+	var out []chatMessage
+	var parts []chatContentPart
+	for _, block := range blocks {
+		switch block.Type {
+		case "tool_result":
+			content, _ := json.Marshal(blocksText(anthropicBlocks(block.Content)))
+			out = append(out, chatMessage{Role: "tool", ToolCallID: block.ToolUseID, Content: content})
+		case "text":
+			parts = append(parts, chatContentPart{Type: "text", Text: block.Text})
+		case "image":
+			if block.Source == nil {
+				continue
+			}
+			part := chatContentPart{Type: "image_url"}
+			part.ImageURL = &struct {
+				URL    string `json:"url"`
+				Detail string `json:"detail,omitempty"`
+			}{URL: block.Source.URL}
+			if block.Source.Type == "base64" {
+				part.ImageURL.URL = "data:" + block.Source.MediaType + ";base64," + block.Source.Data
+			}
+			parts = append(parts, part)
+		}
+	}
+
+	if len(parts) > 0 {
+		var content json.RawMessage
+		if len(parts) == 1 && parts[0].Type == "text" {
+			content, _ = json.Marshal(parts[0].Text)
+		} else {
+			content, _ = json.Marshal(parts)
+		}
+		out = append(out, chatMessage{Role: msg.Role, Content: content})
+	}
+	return out
+}
+
+// ChatToAnthropicResponse converts an OpenAI chat completion body into a
+// Messages API response body.
+func ChatToAnthropicResponse(body []byte) ([]byte, error) {
+	var in chatResponse
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, err
+	}
+
+	out := anthropicResponse{
+		ID:         in.ID,
+		Type:       "message",
+		Role:       "assistant",
+		Model:      in.Model,
+		Content:    []anthropicBlock{},
+		StopReason: "end_turn",
+	}
+
+	if len(in.Choices) > 0 && in.Choices[0].Message != nil {
+		choice := in.Choices[0]
+		if text := contentText(choice.Message.Content); text != "" {
+			out.Content = append(out.Content, anthropicBlock{Type: "text", Text: text})
+		}
+		for _, call := range choice.Message.ToolCalls {
+			out.Content = append(out.Content, anthropicBlock{
+				Type:  "tool_use",
+				ID:    call.ID,
+				Name:  call.Function.Name,
+				Input: toolInput(call.Function.Arguments),
+			})
+		}
+		if choice.FinishReason != nil {
+			out.StopReason = stopReason(*choice.FinishReason)
+		}
+	}
+
+	if in.Usage != nil {
+		out.Usage = anthropicUsage{
+			InputTokens:  in.Usage.PromptTokens,
+			OutputTokens: in.Usage.CompletionTokens,
+		}
+	}
+
+	return marshalDocument(out)
+}
+
+// ChatToAnthropicError converts an OpenAI-style error body into the
+// Messages API error shape.
+func ChatToAnthropicError(status int, body []byte) []byte {
+	var in struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	message := strings.TrimSpace(string(body))
+	if err := json.Unmarshal(body, &in); err == nil && in.Error.Message != "" {
+		message = in.Error.Message
+	}
+
+	out, _ := marshalDocument(map[string]interface{}{
+		"type": "error",
+		"error": map[string]string{
+			"type":    anthropicErrorType(status),
+			"message": message,
+		},
+	})
+	return out
+}
+
+func anthropicErrorType(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusNotFound:
+		return "not_found_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status == StatusOverloaded || status == http.StatusServiceUnavailable:
+		return "overloaded_error"
+	case status >= 500:
+		return "api_error"
+	default:
+		return "invalid_request_error"
+	}
+}
+
+// toolInput parses streamed or complete tool call arguments, falling back
+// to an empty object since the Messages API requires input to be one.
+func toolInput(arguments string) json.RawMessage {
+	if arguments == "" || !json.Valid([]byte(arguments)) {
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(arguments)
+}
+
+// stopReason maps an OpenAI finish_reason onto Anthropic's stop_reason.
+func stopReason(finishReason string) string {
+	switch finishReason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	case "content_filter":
+		return "refusal"
+	default:
+		return "end_turn"
+	}
+}
+
+// NewAnthropicStreamEncoder returns a translator that turns OpenAI chat
+// completion chunks into Messages API stream events.
+func NewAnthropicStreamEncoder() StreamTranslator {
+	return &anthropicStreamEncoder{block: -1}
+}
+
+type anthropicStreamEncoder struct {
+	started      bool
+	finished     bool
+	block        int // index of the open content block, or -1
+	blockType    string
+	nextBlock    int
+	toolBlocks   map[int]int
+	finishReason string
+	usage        Usage
+}
+
+func (e *anthropicStreamEncoder) Translate(ev sse.Event) []sse.Event {
+	if ev.Data == "" {
+		if ev.Comment != "" {
+			return []sse.Event{{Comment: ev.Comment}}
+		}
+		return nil
+	}
+
+	if ev.Data == "[DONE]" {
+		return e.finish()
+	}
+
+	var chunk struct {
+		chatResponse
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(ev.Data), &chunk); err != nil {
+		return nil
+	}
+
+	if len(chunk.Error) > 0 {
+		var upstream struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(chunk.Error, &upstream); err != nil || upstream.Message == "" {
+			upstream.Message = string(chunk.Error)
+		}
+		return []sse.Event{e.event("error", map[string]interface{}{
+			"type":  "error",
+			"error": map[string]interface{}{"type": "api_error", "message": upstream.Message},
+		})}
+	}
+
+	var out []sse.Event
+	if !e.started {
+		e.started = true
+		out = append(out, e.event("message_start", map[string]interface{}{
+			"type": "message_start",
+			"message": map[string]interface{}{
+				"id":            chunk.ID,
+				"type":          "message",
+				"role":          "assistant",
+				"model":         chunk.Model,
+				"content":       []interface{}{},
+				"stop_reason":   nil,
+				"stop_sequence": nil,
+				"usage":         map[string]int{"input_tokens": 0, "output_tokens": 0},
+			},
+		}))
+	}
+
+	if chunk.Usage != nil {
+		e.usage = Usage{PromptTokens: chunk.Usage.PromptTokens, CompletionTokens: chunk.Usage.CompletionTokens}
+	}
+
+	for _, choice := range chunk.Choices {
+		if choice.Delta != nil {
+			if choice.Delta.Content != nil && *choice.Delta.Content != "" {
+				if e.blockType != "text" {
+					out = append(out, e.startBlock("text", map[string]interface{}{"type": "text", "text": ""})...)
+				}
+				out = append(out, e.delta(e.block, map[string]interface{}{"type": "text_delta", "text": *choice.Delta.Content}))
+			}
+
+			for _, call := range choice.Delta.ToolCalls {
+				index := 0
+				if call.Index != nil {
+					index = *call.Index
+				}
+				if call.ID != "" {
+					out = append(out, e.startBlock("tool_use", map[string]interface{}{
+						"type":  "tool_use",
+						"id":    call.ID,
+						"name":  call.Function.Name,
+						"input": map[string]interface{}{},
+					})...)
+					if e.toolBlocks == nil {
+						e.toolBlocks = make(map[int]int)
+					}
+					e.toolBlocks[index] = e.block
+				}
+				block, ok := e.toolBlocks[index]
+				if ok && call.Function.Arguments != "" {
+					out = append(out, e.delta(block, map[string]interface{}{"type": "input_json_delta", "partial_json": call.Function.Arguments}))
+				}
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			e.finishReason = *choice.FinishReason
+		}
+	}
+
+	return out
+}
+
+func (e *anthropicStreamEncoder) Usage() Usage {
+	return e.usage
+}
+
+func (e *anthropicStreamEncoder) startBlock(blockType string, block map[string]interface{}) []sse.Event {
+	out := e.stopBlock()
+	e.block = e.nextBlock
+	e.blockType = blockType
+	e.nextBlock++
+	return append(out, e.event("content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         e.block,
+		"content_block": block,
+	}))
+}
+
+func (e *anthropicStreamEncoder) stopBlock() []sse.Event {
+	if e.block < 0 {
+		return nil
+	}
+	ev := e.event("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": e.block})
+	e.block = -1
+	e.blockType = ""
+	return []sse.Event{ev}
+}
+
+func (e *anthropicStreamEncoder) delta(index int, delta map[string]interface{}) sse.Event {
+	return e.event("content_block_delta", map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": index,
+		"delta": delta,
+	})
+}
+
+func (e *anthropicStreamEncoder) finish() []sse.Event {
+	if e.finished || !e.started {
+		return nil
+	}
+	e.finished = true
+
+	out := e.stopBlock()
+	out = append(out, e.event("message_delta", map[string]interface{}{
+		"type":  "message_delta",
+		"delta": map[string]interface{}{"stop_reason": stopReason(e.finishReason), "stop_sequence": nil},
+		"usage": map[string]int{"input_tokens": e.usage.PromptTokens, "output_tokens": e.usage.CompletionTokens},
+	}))
+	return append(out, e.event("message_stop", map[string]interface{}{"type": "message_stop"}))
+}
+
+func (e *anthropicStreamEncoder) event(name string, data interface{}) sse.Event {
+	encoded, _ := marshalDocument(data)
+	return sse.Event{Name: name, Data: string(encoded)}
+}
@@ -0,0 +1,143 @@
+package provider
+
+import (
+	"encoding/json"
+
+	"github.com/CiaranMcAleer/roxy/internal/sse"
+)
+
+// anthropicStreamTranslator turns a Messages API event stream into OpenAI
+// chat completion chunks.
+type anthropicStreamTranslator struct {
+	id      string
+	model   string
+	created int64
+	usage   Usage
+
+	// toolIndex maps Anthropic content block indexes to OpenAI tool call
+	// indexes, which only count tool calls.
+	toolIndex map[int]int
+}
+
+type anthropicStreamEvent struct {
+	Type         string             `json:"type"`
+	Index        int                `json:"index"`
+	Message      *anthropicResponse `json:"message"`
+	ContentBlock *anthropicBlock    `json:"content_block"`
+	Delta        *struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *anthropicUsage  `json:"usage"`
+	Error *json.RawMessage `json:"error"`
+}
+
+func (t *anthropicStreamTranslator) Translate(ev sse.Event) []sse.Event {
+	if ev.Data == "" {
+		// Keep-alive comments are relayed so the client connection stays warm
+		if ev.Comment != "" {
+			return []sse.Event{{Comment: ev.Comment}}
+		}
+		return nil
+	}
+
+	var in anthropicStreamEvent
+	if err := json.Unmarshal([]byte(ev.Data), &in); err != nil {
+		return nil
+	}
+
+	switch in.Type {
+	case "message_start":
+		if in.Message != nil {
+			t.id = in.Message.ID
+			t.model = in.Message.Model
+			t.usage.PromptTokens = in.Message.Usage.usage().PromptTokens
+		}
+		return t.chunk(&chatDelta{Role: "assistant", Content: stringPtr("")}, nil, nil)
+
+	case "content_block_start":
+		if in.ContentBlock == nil {
+			return nil
+		}
+		switch in.ContentBlock.Type {
+		case "tool_use":
+			if t.toolIndex == nil {
+				t.toolIndex = make(map[int]int)
+			}
+			index := len(t.toolIndex)
+			t.toolIndex[in.Index] = index
+			return t.chunk(&chatDelta{ToolCalls: []chatToolCall{{
+				Index:    &index,
+				ID:       in.ContentBlock.ID,
+				Type:     "function",
+				Function: chatFunction{Name: in.ContentBlock.Name},
+			}}}, nil, nil)
+		case "text":
+			if in.ContentBlock.Text != "" {
+				return t.chunk(&chatDelta{Content: stringPtr(in.ContentBlock.Text)}, nil, nil)
+			}
+		}
+		return nil
+
+	case "content_block_delta":
+		if in.Delta == nil {
+			return nil
+		}
+		switch in.Delta.Type {
+		case "text_delta":
+			return t.chunk(&chatDelta{Content: stringPtr(in.Delta.Text)}, nil, nil)
+		case "input_json_delta":
+			index, ok := t.toolIndex[in.Index]
+			if !ok {
+				return nil
+			}
+			return t.chunk(&chatDelta{ToolCalls: []chatToolCall{{
+				Index:    &index,
+				Function: chatFunction{Arguments: in.Delta.PartialJSON},
+			}}}, nil, nil)
+		}
+		return nil
+
+	case "message_delta":
+		if in.Usage != nil {
+			t.usage.CompletionTokens = in.Usage.OutputTokens
+		}
+		if in.Delta == nil || in.Delta.StopReason == "" {
+			return nil
+		}
+		return t.chunk(&chatDelta{}, stringPtr(finishReason(in.Delta.StopReason)), newChatUsage(t.usage))
+
+	case "message_stop":
+		return []sse.Event{{Data: "[DONE]"}}
+
+	case "ping":
+		return []sse.Event{{Comment: "ping"}}
+
+	case "error":
+		data, _ := json.Marshal(map[string]interface{}{"error": in.Error})
+		return []sse.Event{{Data: string(data)}}
+	}
+
+	return nil
+}
+
+func (t *anthropicStreamTranslator) Usage() Usage {
+	return t.usage
+}
+
+func (t *anthropicStreamTranslator) chunk(delta *chatDelta, finish *string, usage *chatUsage) []sse.Event {
+	data, err := marshalDocument(&chatResponse{
+		ID:      t.id,
+		Object:  "chat.completion.chunk",
+		Created: t.created,
+		Model:   t.model,
+		Choices: []chatChoice{{Delta: delta, FinishReason: finish}},
+		Usage:   usage,
+	})
+	if err != nil {
+		return nil
+	}
+	return []sse.Event{{Data: string(data)}}
+}
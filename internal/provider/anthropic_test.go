@@ -0,0 +1,371 @@
+package provider
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/CiaranMcAleer/roxy/internal/sse"
+)
+
+func TestAnthropicTranslateRequest(t *testing.T) {
+	p := &Anthropic{baseURL: "https://anthropic.example/v1"}
+
+	body := `{
+		"model": "gpt-4",
+		"messages": [
+			{"role": "system", "content": "Be brief."},
+			{"role": "user", "content": [
+				{"type": "text", "text": "What is this?"},
+				{"type": "image_url", "image_url": {"url": "data:image/png;base64,AAAA"}}
+			]},
+			{"role": "assistant", "content": null, "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "lookup", "arguments": "{\"q\":\"cat\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "A cat"},
+			{"role": "user", "content": "Thanks"}
+		],
+		"tools": [{"type": "function", "function": {"name": "lookup", "description": "Look up", "parameters": {"type": "object"}}}],
+		"tool_choice": "required",
+		"temperature": 1.5,
+		"stop": "END",
+		"user": "user-1",
+		"stream": true
+	}`
+
+	out, err := p.TranslateRequest([]byte(body), "claude-3-5-sonnet")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var req anthropicRequest
+	if err := json.Unmarshal(out, &req); err != nil {
+		t.Fatalf("Failed to decode translated request: %v", err)
+	}
+
+	if req.Model != "claude-3-5-sonnet" {
+		t.Errorf("Expected model claude-3-5-sonnet, got %s", req.Model)
+	}
+	if req.System != "Be brief." {
+		t.Errorf("Expected system prompt to be extracted, got %q", req.System)
+	}
+	if req.MaxTokens != anthropicDefaultMaxTokens {
+		t.Errorf("Expected default max_tokens %d, got %d", anthropicDefaultMaxTokens, req.MaxTokens)
+	}
+	if req.Temperature == nil || *req.Temperature != 1 {
+		t.Errorf("Expected temperature clamped to 1, got %v", req.Temperature)
+	}
+	if len(req.StopSequences) != 1 || req.StopSequences[0] != "END" {
+		t.Errorf("Unexpected stop sequences: %v", req.StopSequences)
+	}
+	if !req.Stream {
+		t.Error("Expected stream to be preserved")
+	}
+	if req.Metadata == nil || req.Metadata.UserID != "user-1" {
+		t.Errorf("Expected user to map to metadata.user_id, got %+v", req.Metadata)
+	}
+	if len(req.Tools) != 1 || req.Tools[0].Name != "lookup" || string(req.Tools[0].InputSchema) != `{"type":"object"}` {
+		t.Errorf("Unexpected tools: %+v", req.Tools)
+	}
+	if req.ToolChoice == nil || req.ToolChoice.Type != "any" {
+		t.Errorf("Expected tool_choice any, got %+v", req.ToolChoice)
+	}
+
+	// user, assistant tool call, then the tool result merged with the
+	// following user turn
+	if len(req.Messages) != 3 {
+		t.Fatalf("Expected 3 alternating messages, got %d: %s", len(req.Messages), out)
+	}
+
+	user := req.Messages[0]
+	if user.Role != "user" || len(user.Content) != 2 || user.Content[1].Type != "image" {
+		t.Fatalf("Unexpected first user message: %+v", user)
+	}
+	if src := user.Content[1].Source; src.Type != "base64" || src.MediaType != "image/png" || src.Data != "AAAA" {
+		t.Errorf("Unexpected image source: %+v", src)
+	}
+
+	assistant := req.Messages[1]
+	if assistant.Role != "assistant" || len(assistant.Content) != 1 || assistant.Content[0].Type != "tool_use" {
+		t.Fatalf("Unexpected assistant message: %+v", assistant)
+	}
+	if assistant.Content[0].ID != "call_1" || string(assistant.Content[0].Input) != `{"q":"cat"}` {
+		t.Errorf("Unexpected tool_use block: %+v", assistant.Content[0])
+	}
+
+	result := req.Messages[2]
+	if result.Role != "user" || len(result.Content) != 2 {
+		t.Fatalf("Unexpected tool result message: %+v", result)
+	}
+	if result.Content[0].Type != "tool_result" || result.Content[0].ToolUseID != "call_1" || string(result.Content[0].Content) != `"A cat"` {
+		t.Errorf("Unexpected tool_result block: %+v", result.Content[0])
+	}
+	if result.Content[1].Type != "text" || result.Content[1].Text != "Thanks" {
+		t.Errorf("Unexpected trailing text block: %+v", result.Content[1])
+	}
+}
+
+func TestAnthropicTranslateResponse(t *testing.T) {
+	p := &Anthropic{}
+
+	body := `{
+		"id": "msg_1",
+		"type": "message",
+		"role": "assistant",
+		"model": "claude-3-5-sonnet",
+		"content": [
+			{"type": "text", "text": "Let me check."},
+			{"type": "tool_use", "id": "toolu_1", "name": "lookup", "input": {"q": "cat"}}
+		],
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 10, "output_tokens": 5, "cache_read_input_tokens": 3}
+	}`
+
+	out, err := p.TranslateResponse([]byte(body))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var resp chatResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		t.Fatalf("Failed to decode translated response: %v", err)
+	}
+
+	if resp.Object != "chat.completion" || resp.Model != "claude-3-5-sonnet" {
+		t.Errorf("Unexpected response envelope: %+v", resp)
+	}
+	choice := resp.Choices[0]
+	if *choice.FinishReason != "tool_calls" {
+		t.Errorf("Expected finish_reason tool_calls, got %s", *choice.FinishReason)
+	}
+	if string(choice.Message.Content) != `"Let me check."` {
+		t.Errorf("Unexpected content: %s", choice.Message.Content)
+	}
+	if len(choice.Message.ToolCalls) != 1 || choice.Message.ToolCalls[0].Function.Arguments != `{"q":"cat"}` {
+		t.Errorf("Unexpected tool calls: %+v", choice.Message.ToolCalls)
+	}
+	if resp.Usage.PromptTokens != 13 || resp.Usage.CompletionTokens != 5 || resp.Usage.TotalTokens != 18 {
+		t.Errorf("Unexpected usage: %+v", resp.Usage)
+	}
+
+	if usage := p.ParseUsage([]byte(body)); usage.Total() != 18 {
+		t.Errorf("Expected parsed usage of 18 tokens, got %d", usage.Total())
+	}
+}
+
+func TestAnthropicTranslateStream(t *testing.T) {
+	p := &Anthropic{}
+
+	stream := strings.Join([]string{
+		`event: message_start`,
+		`data: {"type":"message_start","message":{"id":"msg_1","model":"claude-3-5-sonnet","usage":{"input_tokens":10,"output_tokens":1}}}`,
+		``,
+		`event: content_block_start`,
+		`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		``,
+		`event: content_block_delta`,
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}`,
+		``,
+		`event: ping`,
+		`data: {"type":"ping"}`,
+		``,
+		`event: content_block_start`,
+		`data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"lookup","input":{}}}`,
+		``,
+		`event: content_block_delta`,
+		`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"q\":"}}`,
+		``,
+		`event: message_delta`,
+		`data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":7}}`,
+		``,
+		`event: message_stop`,
+		`data: {"type":"message_stop"}`,
+		``,
+	}, "\n")
+
+	translator := p.TranslateStream(nil)
+	reader := sse.NewReader(strings.NewReader(stream))
+	var chunks []chatResponse
+	var comments int
+	done := false
+	for {
+		ev, err := reader.Next()
+		if err != nil {
+			break
+		}
+		for _, out := range translator.Translate(ev) {
+			switch {
+			case out.Data == "[DONE]":
+				done = true
+			case out.Data == "":
+				comments++
+			default:
+				var chunk chatResponse
+				if err := json.Unmarshal([]byte(out.Data), &chunk); err != nil {
+					t.Fatalf("Failed to decode chunk %q: %v", out.Data, err)
+				}
+				chunks = append(chunks, chunk)
+			}
+		}
+	}
+
+	if !done {
+		t.Error("Expected stream to end with [DONE]")
+	}
+	if comments != 1 {
+		t.Errorf("Expected ping to be relayed as a keep-alive comment, got %d comments", comments)
+	}
+	if len(chunks) != 5 {
+		t.Fatalf("Expected 5 chunks, got %d", len(chunks))
+	}
+
+	if delta := chunks[0].Choices[0].Delta; delta.Role != "assistant" || chunks[0].ID != "msg_1" {
+		t.Errorf("Unexpected first chunk: %+v", chunks[0])
+	}
+	if delta := chunks[1].Choices[0].Delta; delta.Content == nil || *delta.Content != "Hi" {
+		t.Errorf("Unexpected text chunk: %+v", delta)
+	}
+	if calls := chunks[2].Choices[0].Delta.ToolCalls; len(calls) != 1 || *calls[0].Index != 0 || calls[0].ID != "toolu_1" {
+		t.Errorf("Unexpected tool call start chunk: %+v", calls)
+	}
+	if calls := chunks[3].Choices[0].Delta.ToolCalls; len(calls) != 1 || calls[0].Function.Arguments != `{"q":` {
+		t.Errorf("Unexpected tool call argument chunk: %+v", calls)
+	}
+	last := chunks[4]
+	if last.Choices[0].FinishReason == nil || *last.Choices[0].FinishReason != "tool_calls" {
+		t.Errorf("Expected final finish_reason tool_calls, got %+v", last.Choices[0])
+	}
+	if last.Usage == nil || last.Usage.TotalTokens != 17 {
+		t.Errorf("Expected final usage of 17 tokens, got %+v", last.Usage)
+	}
+	if translator.Usage().Total() != 17 {
+		t.Errorf("Expected translator usage of 17 tokens, got %d", translator.Usage().Total())
+	}
+}
+
+func TestAnthropicAuthenticate(t *testing.T) {
+	p := &Anthropic{baseURL: "https://anthropic.example/v1"}
+
+	req, _ := http.NewRequest("POST", p.URL(), nil)
+	p.Authenticate(req, "secret")
+
+	if req.URL.Path != "/v1/messages" {
+		t.Errorf("Expected Messages API endpoint, got %s", req.URL.Path)
+	}
+	if req.Header.Get("X-Api-Key") != "secret" {
+		t.Errorf("Expected X-Api-Key to be set")
+	}
+	if req.Header.Get("Anthropic-Version") != anthropicVersion {
+		t.Errorf("Expected anthropic-version %s, got %q", anthropicVersion, req.Header.Get("Anthropic-Version"))
+	}
+}
+
+func TestAnthropicToChatRequest(t *testing.T) {
+	body := `{
+		"model": "claude-3-5-sonnet",
+		"max_tokens": 256,
+		"system": [{"type": "text", "text": "Be brief."}],
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "What is this?"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/jpeg", "data": "BBBB"}}
+			]},
+			{"role": "assistant", "content": [
+				{"type": "text", "text": "Checking."},
+				{"type": "tool_use", "id": "toolu_1", "name": "lookup", "input": {"q": "cat"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": "A cat"},
+				{"type": "text", "text": "Thanks"}
+			]}
+		],
+		"tools": [{"name": "lookup", "input_schema": {"type": "object"}}],
+		"tool_choice": {"type": "tool", "name": "lookup", "disable_parallel_tool_use": true},
+		"stop_sequences": ["END"],
+		"stream": true
+	}`
+
+	out, err := AnthropicToChatRequest([]byte(body))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var req struct {
+		chatRequest
+		StreamOptions struct {
+			IncludeUsage bool `json:"include_usage"`
+		} `json:"stream_options"`
+	}
+	if err := json.Unmarshal(out, &req); err != nil {
+		t.Fatalf("Failed to decode translated request: %v", err)
+	}
+
+	if req.Model != "claude-3-5-sonnet" || *req.MaxTokens != 256 || !req.Stream || !req.StreamOptions.IncludeUsage {
+		t.Errorf("Unexpected request envelope: %s", out)
+	}
+	if stop := stringList(req.Stop); len(stop) != 1 || stop[0] != "END" {
+		t.Errorf("Unexpected stop: %s", req.Stop)
+	}
+	if string(req.ToolChoice) != `{"function":{"name":"lookup"},"type":"function"}` || req.ParallelToolCalls == nil || *req.ParallelToolCalls {
+		t.Errorf("Unexpected tool choice: %s, parallel %v", req.ToolChoice, req.ParallelToolCalls)
+	}
+	if len(req.Tools) != 1 || req.Tools[0].Function.Name != "lookup" {
+		t.Errorf("Unexpected tools: %+v", req.Tools)
+	}
+
+	roles := make([]string, len(req.Messages))
+	for i, msg := range req.Messages {
+		roles[i] = msg.Role
+	}
+	if strings.Join(roles, ",") != "system,user,assistant,tool,user" {
+		t.Fatalf("Unexpected message roles: %v", roles)
+	}
+
+	parts := contentParts(req.Messages[1].Content)
+	if len(parts) != 2 || parts[1].ImageURL == nil || parts[1].ImageURL.URL != "data:image/jpeg;base64,BBBB" {
+		t.Errorf("Unexpected user content: %s", req.Messages[1].Content)
+	}
+	assistant := req.Messages[2]
+	if contentText(assistant.Content) != "Checking." || len(assistant.ToolCalls) != 1 || assistant.ToolCalls[0].Function.Arguments != `{"q":"cat"}` {
+		t.Errorf("Unexpected assistant message: %+v", assistant)
+	}
+	if tool := req.Messages[3]; tool.ToolCallID != "toolu_1" || contentText(tool.Content) != "A cat" {
+		t.Errorf("Unexpected tool message: %+v", tool)
+	}
+}
+
+func TestChatToAnthropicResponse(t *testing.T) {
+	body := `{
+		"id": "chatcmpl-1",
+		"object": "chat.completion",
+		"model": "gpt-4o",
+		"choices": [{
+			"index": 0,
+			"message": {"role": "assistant", "content": null, "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "lookup", "arguments": "{\"q\":\"cat\"}"}}
+			]},
+			"finish_reason": "tool_calls"
+		}],
+		"usage": {"prompt_tokens": 12, "completion_tokens": 4, "total_tokens": 16}
+	}`
+
+	out, err := ChatToAnthropicResponse([]byte(body))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var resp anthropicResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		t.Fatalf("Failed to decode translated response: %v", err)
+	}
+	if resp.Type != "message" || resp.StopReason != "tool_use" {
+		t.Errorf("Unexpected response envelope: %s", out)
+	}
+	if len(resp.Content) != 1 || resp.Content[0].Type != "tool_use" || string(resp.Content[0].Input) != `{"q":"cat"}` {
+		t.Errorf("Unexpected content: %+v", resp.Content)
+	}
+	if resp.Usage.InputTokens != 12 || resp.Usage.OutputTokens != 4 {
+		t.Errorf("Unexpected usage: %+v", resp.Usage)
+	}
+}
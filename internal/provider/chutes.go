@@ -0,0 +1,20 @@
+package provider
+
+import (
+	"github.com/CiaranMcAleer/roxy/internal/config"
+)
+
+func init() {
+	Register("chutes", func(cfg *config.Config) Provider {
+		return &Chutes{OpenAI: OpenAI{baseURL: cfg.Providers.Chutes.BaseURL}}
+	})
+}
+
+// Chutes serves an OpenAI-compatible API with bearer authentication.
+type Chutes struct {
+	OpenAI
+}
+
+func (p *Chutes) Name() string {
+	return "chutes"
+}
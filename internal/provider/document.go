@@ -0,0 +1,59 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// SetModel returns body with only its top-level model field replaced. All
+// other fields are carried over verbatim, including ones Roxy doesn't know.
+func SetModel(body []byte, model string) ([]byte, error) {
+	return editDocument(body, func(doc map[string]json.RawMessage) error {
+		return setField(doc, "model", model)
+	})
+}
+
+// DropFields returns body without the named top-level fields. All other
+// fields are carried over verbatim.
+func DropFields(body []byte, names ...string) ([]byte, error) {
+	return editDocument(body, func(doc map[string]json.RawMessage) error {
+		for _, name := range names {
+			delete(doc, name)
+		}
+		return nil
+	})
+}
+
+// editDocument decodes the top level of body, applies edit and re-encodes
+// it. Nested values are carried over verbatim.
+func editDocument(body []byte, edit func(doc map[string]json.RawMessage) error) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	if err := edit(doc); err != nil {
+		return nil, err
+	}
+	return marshalDocument(doc)
+}
+
+func setField(doc map[string]json.RawMessage, name string, value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	doc[name] = encoded
+	return nil
+}
+
+// marshalDocument encodes doc without HTML escaping so that prompt text is
+// sent upstream byte-for-byte as the client wrote it.
+func marshalDocument(doc interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
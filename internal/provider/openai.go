@@ -0,0 +1,144 @@
+package provider
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/CiaranMcAleer/roxy/internal/config"
+	"github.com/CiaranMcAleer/roxy/internal/sse"
+)
+
+func init() {
+	Register("openai", func(cfg *config.Config) Provider {
+		return &OpenAI{baseURL: cfg.Providers.OpenAI.BaseURL}
+	})
+}
+
+// OpenAI speaks the chat completions API natively, so requests and
+// responses pass through with only the model rewritten.
+type OpenAI struct {
+	baseURL string
+}
+
+func (p *OpenAI) Name() string {
+	return "openai"
+}
+
+func (p *OpenAI) URL() string {
+	return p.baseURL + "/chat/completions"
+}
+
+func (p *OpenAI) Authenticate(req *http.Request, key string) {
+	req.Header.Set("Authorization", "Bearer "+key)
+}
+
+// TranslateRequest rewrites the model and, for streamed requests, asks for
+// the final usage chunk so that streamed tokens can be accounted for.
+func (p *OpenAI) TranslateRequest(body []byte, model string) ([]byte, error) {
+	return editDocument(body, func(doc map[string]json.RawMessage) error {
+		if err := setField(doc, "model", model); err != nil {
+			return err
+		}
+
+		var stream bool
+		json.Unmarshal(doc["stream"], &stream)
+		if !stream {
+			return nil
+		}
+
+		options := make(map[string]json.RawMessage)
+		if raw, ok := doc["stream_options"]; ok {
+			json.Unmarshal(raw, &options)
+		}
+		options["include_usage"] = json.RawMessage("true")
+		return setField(doc, "stream_options", options)
+	})
+}
+
+func (p *OpenAI) TranslateResponse(body []byte) ([]byte, error) {
+	return body, nil
+}
+
+func (p *OpenAI) TranslateStream(body []byte) StreamTranslator {
+	var req struct {
+		StreamOptions struct {
+			IncludeUsage bool `json:"include_usage"`
+		} `json:"stream_options"`
+	}
+	json.Unmarshal(body, &req)
+
+	return &passthroughStream{
+		parseUsage: p.ParseUsage,
+		hideUsage:  !req.StreamOptions.IncludeUsage,
+	}
+}
+
+func (p *OpenAI) ParseUsage(body []byte) Usage {
+	var resp struct {
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return Usage{}
+	}
+	return Usage{
+		PromptTokens:     resp.Usage.PromptTokens,
+		CompletionTokens: resp.Usage.CompletionTokens,
+	}
+}
+
+func (p *OpenAI) ClassifyError(status int, body []byte) ErrorClass {
+	// A 429 for an exhausted billing quota won't clear by waiting, so it is
+	// treated like a credential problem rather than a rate limit
+	if status == http.StatusTooManyRequests && errorCode(body) == "insufficient_quota" {
+		return ErrAuth
+	}
+	return classifyStatus(status)
+}
+
+// errorCode returns the error.code field of an OpenAI-style error body.
+func errorCode(body []byte) string {
+	var resp struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(body, &resp)
+	return resp.Error.Code
+}
+
+// passthroughStream relays events unchanged, picking up token usage from
+// any chunk that carries it. The usage-only chunk Roxy requested on the
+// client's behalf is dropped unless the client asked for it itself.
+type passthroughStream struct {
+	parseUsage func(body []byte) Usage
+	hideUsage  bool
+	usage      Usage
+}
+
+func (t *passthroughStream) Translate(ev sse.Event) []sse.Event {
+	if !strings.Contains(ev.Data, `"usage"`) {
+		return []sse.Event{ev}
+	}
+
+	if usage := t.parseUsage([]byte(ev.Data)); usage.Total() > 0 {
+		t.usage = usage
+	}
+
+	if t.hideUsage {
+		var chunk struct {
+			Choices []json.RawMessage `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(ev.Data), &chunk); err == nil && len(chunk.Choices) == 0 {
+			return nil
+		}
+	}
+	return []sse.Event{ev}
+}
+
+func (t *passthroughStream) Usage() Usage {
+	return t.usage
+}
@@ -0,0 +1,47 @@
+package provider
+
+import (
+	"net/http"
+
+	"github.com/CiaranMcAleer/roxy/internal/config"
+)
+
+func init() {
+	Register("openrouter", func(cfg *config.Config) Provider {
+		return &OpenRouter{
+			OpenAI:  OpenAI{baseURL: cfg.Providers.OpenRouter.BaseURL},
+			referer: cfg.Providers.OpenRouter.Referer,
+			title:   cfg.Providers.OpenRouter.Title,
+		}
+	})
+}
+
+// OpenRouter is OpenAI-compatible, with optional attribution headers that
+// identify the deployment on OpenRouter's rankings.
+type OpenRouter struct {
+	OpenAI
+	referer string
+	title   string
+}
+
+func (p *OpenRouter) Name() string {
+	return "openrouter"
+}
+
+func (p *OpenRouter) Authenticate(req *http.Request, key string) {
+	p.OpenAI.Authenticate(req, key)
+	if p.referer != "" {
+		req.Header.Set("HTTP-Referer", p.referer)
+	}
+	if p.title != "" {
+		req.Header.Set("X-Title", p.title)
+	}
+}
+
+func (p *OpenRouter) ClassifyError(status int, body []byte) ErrorClass {
+	// OpenRouter answers 402 once the account runs out of credits
+	if status == http.StatusPaymentRequired {
+		return ErrAuth
+	}
+	return p.OpenAI.ClassifyError(status, body)
+}
@@ -0,0 +1,172 @@
+// Package provider adapts Roxy's OpenAI-shaped chat completion requests to
+// the individual upstream LLM APIs. Each provider lives in its own file and
+// registers itself under the name used in the api_keys section of the config.
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/CiaranMcAleer/roxy/internal/config"
+	"github.com/CiaranMcAleer/roxy/internal/sse"
+)
+
+// Provider is the adapter between Roxy and a single upstream API.
+type Provider interface {
+	// Name returns the provider name as used in the config.
+	Name() string
+
+	// URL returns the upstream chat completion endpoint.
+	URL() string
+
+	// Authenticate sets the credentials for key on an upstream request.
+	Authenticate(req *http.Request, key string)
+
+	// TranslateRequest converts an OpenAI-shaped request body into the
+	// provider's format, addressed to model.
+	TranslateRequest(body []byte, model string) ([]byte, error)
+
+	// TranslateResponse converts a successful response body from the
+	// provider's format into the OpenAI shape.
+	TranslateResponse(body []byte) ([]byte, error)
+
+	// TranslateStream returns a translator for a single streamed response
+	// that converts the provider's events into OpenAI chunk events. body is
+	// the OpenAI-shaped request the client sent.
+	TranslateStream(body []byte) StreamTranslator
+
+	// ParseUsage extracts token usage from a response body in the
+	// provider's format.
+	ParseUsage(body []byte) Usage
+
+	// ClassifyError reports what kind of failure an upstream response
+	// with the given status and body represents.
+	ClassifyError(status int, body []byte) ErrorClass
+}
+
+// StreamTranslator converts the events of one streamed response into the
+// events sent to the client.
+type StreamTranslator interface {
+	// Translate returns the client events for ev, which may be none.
+	Translate(ev sse.Event) []sse.Event
+
+	// Usage returns the token usage seen on the stream so far.
+	Usage() Usage
+}
+
+// Usage is the token usage reported for a single request.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+func (u Usage) Total() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// ErrorClass categorises upstream failures so callers can decide whether to
+// retry, rotate keys or give up.
+type ErrorClass int
+
+const (
+	ErrNone ErrorClass = iota
+	ErrRateLimited
+	ErrOverloaded
+	ErrAuth
+	ErrServer
+	ErrClient
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrNone:
+		return "none"
+	case ErrRateLimited:
+		return "rate_limited"
+	case ErrOverloaded:
+		return "overloaded"
+	case ErrAuth:
+		return "auth"
+	case ErrServer:
+		return "server"
+	case ErrClient:
+		return "client"
+	default:
+		return fmt.Sprintf("ErrorClass(%d)", int(c))
+	}
+}
+
+// Factory builds a provider from the proxy configuration.
+type Factory func(cfg *config.Config) Provider
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a provider available under name. It is intended to be
+// called from the init function of the file implementing the provider.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if _, exists := factories[name]; exists {
+		panic("provider: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// Names returns the names of all registered providers in sorted order.
+func Names() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Registry holds one configured adapter per registered provider.
+type Registry struct {
+	providers map[string]Provider
+}
+
+func NewRegistry(cfg *config.Config) *Registry {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	providers := make(map[string]Provider, len(factories))
+	for name, factory := range factories {
+		providers[name] = factory(cfg)
+	}
+	return &Registry{providers: providers}
+}
+
+func (r *Registry) Get(name string) (Provider, bool) {
+	p, ok := r.providers[name]
+	return p, ok
+}
+
+// classifyStatus is the default error classification shared by providers
+// that follow the usual HTTP status conventions.
+func classifyStatus(status int) ErrorClass {
+	switch {
+	case status < 400:
+		return ErrNone
+	case status == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrAuth
+	case status == http.StatusServiceUnavailable:
+		return ErrOverloaded
+	case status >= 500:
+		return ErrServer
+	default:
+		return ErrClient
+	}
+}
@@ -0,0 +1,117 @@
+package provider
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/CiaranMcAleer/roxy/internal/config"
+)
+
+func TestRegistry(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.OpenAI.BaseURL = "https://openai.example/v1"
+	cfg.Providers.Anthropic.BaseURL = "https://anthropic.example/v1"
+
+	registry := NewRegistry(cfg)
+	for _, name := range Names() {
+		p, ok := registry.Get(name)
+		if !ok {
+			t.Fatalf("Registered provider %s missing from registry", name)
+		}
+		if p.Name() != name {
+			t.Errorf("Provider registered as %s reports name %s", name, p.Name())
+		}
+	}
+
+	p, _ := registry.Get("openai")
+	if p.URL() != "https://openai.example/v1/chat/completions" {
+		t.Errorf("Unexpected OpenAI URL: %s", p.URL())
+	}
+
+	if _, ok := registry.Get("unknown"); ok {
+		t.Error("Expected unknown provider to be missing")
+	}
+}
+
+func TestEveryConfiguredProviderIsRegistered(t *testing.T) {
+	registered := make(map[string]bool)
+	for _, name := range Names() {
+		registered[name] = true
+	}
+
+	for _, name := range config.ProviderNames() {
+		if !registered[name] {
+			t.Errorf("Provider %s is accepted by the config but has no adapter", name)
+		}
+	}
+}
+
+func TestSetModel(t *testing.T) {
+	body := []byte(`{"model":"gpt-4","tools":[{"type":"function"}],"seed":12345678901234567,"messages":[{"role":"user","content":"<b>hi</b>"}]}`)
+
+	out, err := SetModel(body, "gpt-4o")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("Failed to decode output: %v", err)
+	}
+	if string(doc["model"]) != `"gpt-4o"` {
+		t.Errorf("Expected model gpt-4o, got %s", doc["model"])
+	}
+	if string(doc["seed"]) != "12345678901234567" {
+		t.Errorf("Seed changed: %s", doc["seed"])
+	}
+	if string(doc["messages"]) != `[{"role":"user","content":"<b>hi</b>"}]` {
+		t.Errorf("Messages changed: %s", doc["messages"])
+	}
+	if string(doc["tools"]) != `[{"type":"function"}]` {
+		t.Errorf("Tools changed: %s", doc["tools"])
+	}
+}
+
+func TestDropFields(t *testing.T) {
+	body := []byte(`{"model":"gpt-4","stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"<b>hi</b>"}]}`)
+
+	out, err := DropFields(body, "stream", "stream_options")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(out) != `{"messages":[{"role":"user","content":"<b>hi</b>"}],"model":"gpt-4"}` {
+		t.Errorf("Unexpected output: %s", out)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	openai := &OpenAI{}
+	anthropic := &Anthropic{}
+
+	testCases := []struct {
+		name     string
+		provider Provider
+		status   int
+		body     string
+		want     ErrorClass
+	}{
+		{"success", openai, http.StatusOK, "", ErrNone},
+		{"rate limit", openai, http.StatusTooManyRequests, `{"error":{"code":"rate_limit_exceeded"}}`, ErrRateLimited},
+		{"quota exhausted", openai, http.StatusTooManyRequests, `{"error":{"code":"insufficient_quota"}}`, ErrAuth},
+		{"bad key", openai, http.StatusUnauthorized, "", ErrAuth},
+		{"bad request", openai, http.StatusBadRequest, "", ErrClient},
+		{"server error", openai, http.StatusInternalServerError, "", ErrServer},
+		{"unavailable", openai, http.StatusServiceUnavailable, "", ErrOverloaded},
+		{"anthropic overloaded", anthropic, 529, "", ErrOverloaded},
+		{"anthropic forbidden", anthropic, http.StatusForbidden, "", ErrAuth},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.provider.ClassifyError(tc.status, []byte(tc.body)); got != tc.want {
+				t.Errorf("Got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
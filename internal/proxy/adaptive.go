@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/CiaranMcAleer/roxy/internal/provider"
+	"github.com/CiaranMcAleer/roxy/internal/sse"
+)
+
+const (
+	// healthAlpha weighs the newest sample in each moving average.
+	healthAlpha = 0.2
+	// exploreRate is the share of adaptive requests sent to a random
+	// target, so that targets that performed badly get a chance to show
+	// they have recovered.
+	exploreRate = 0.1
+)
+
+// targetHealth holds exponentially weighted moving averages of how a
+// target model has been performing.
+type targetHealth struct {
+	latency    float64 // Milliseconds until the response was complete
+	firstToken float64 // Milliseconds until the first token arrived
+	errorRate  float64 // Share of recent attempts that failed
+	attempts   int
+	successes  int
+}
+
+// cost ranks targets for the adaptive policy: the expected time to a
+// complete answer, inflated by the chance of having to retry elsewhere.
+// A target that has only ever failed ranks last.
+func (t *targetHealth) cost() float64 {
+	if t.successes == 0 {
+		return math.Inf(1)
+	}
+	success := math.Max(1-t.errorRate, 0.05)
+	return (t.firstToken + t.latency) / 2 / success
+}
+
+// healthTracker records the performance of every target model requests
+// have been sent to, keyed by the target as written in the rule, which
+// names its provider if it isn't implied.
+type healthTracker struct {
+	mu      sync.Mutex
+	targets map[string]*targetHealth
+	random  func() float64
+}
+
+func newHealthTracker() *healthTracker {
+	return &healthTracker{
+		targets: make(map[string]*targetHealth),
+		random:  rand.Float64,
+	}
+}
+
+func (h *healthTracker) target(model string) *targetHealth {
+	t, ok := h.targets[model]
+	if !ok {
+		t = &targetHealth{}
+		h.targets[model] = t
+	}
+	return t
+}
+
+func ewma(avg, sample float64, first bool) float64 {
+	if first {
+		return sample
+	}
+	return healthAlpha*sample + (1-healthAlpha)*avg
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// recordSuccess records a completed response from model.
+func (h *healthTracker) recordSuccess(model string, firstToken, latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t := h.target(model)
+	t.latency = ewma(t.latency, milliseconds(latency), t.successes == 0)
+	t.firstToken = ewma(t.firstToken, milliseconds(firstToken), t.successes == 0)
+	t.errorRate = ewma(t.errorRate, 0, t.attempts == 0)
+	t.attempts++
+	t.successes++
+}
+
+// recordFailure records a failed attempt on model.
+func (h *healthTracker) recordFailure(model string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t := h.target(model)
+	t.errorRate = ewma(t.errorRate, 1, t.attempts == 0)
+	t.attempts++
+}
+
+// pick returns the healthiest of models. Models that have never been
+// tried come first, and now and then a random model is picked instead.
+func (h *healthTracker) pick(models []string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(models) > 1 && h.random() < exploreRate {
+		return models[int(h.random()*float64(len(models)))%len(models)]
+	}
+
+	best, bestCost := models[0], math.Inf(1)
+	for _, model := range models {
+		t := h.target(model)
+		if t.attempts == 0 {
+			return model
+		}
+		if c := t.cost(); c < bestCost {
+			best, bestCost = model, c
+		}
+	}
+	return best
+}
+
+// timedStream notes when the first event of a stream arrives.
+type timedStream struct {
+	provider.StreamTranslator
+	first time.Time
+}
+
+func (t *timedStream) Translate(ev sse.Event) []sse.Event {
+	if t.first.IsZero() {
+		t.first = time.Now()
+	}
+	return t.StreamTranslator.Translate(ev)
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CiaranMcAleer/roxy/internal/config"
+	"github.com/CiaranMcAleer/roxy/internal/testutils"
+)
+
+func TestHealthTrackerPick(t *testing.T) {
+	models := []string{"gpt-4o", "claude-3-haiku", "gpt-4o-mini"}
+
+	tests := []struct {
+		name    string
+		record  func(h *healthTracker)
+		explore float64
+		want    string
+	}{
+		{
+			name: "untried targets first",
+			record: func(h *healthTracker) {
+				h.recordSuccess("gpt-4o", 10*time.Millisecond, 20*time.Millisecond)
+			},
+			explore: 1,
+			want:    "claude-3-haiku",
+		},
+		{
+			name: "fastest target",
+			record: func(h *healthTracker) {
+				h.recordSuccess("gpt-4o", 300*time.Millisecond, 900*time.Millisecond)
+				h.recordSuccess("claude-3-haiku", 100*time.Millisecond, 400*time.Millisecond)
+				h.recordSuccess("gpt-4o-mini", 200*time.Millisecond, 500*time.Millisecond)
+			},
+			explore: 1,
+			want:    "claude-3-haiku",
+		},
+		{
+			name: "errors outweigh speed",
+			record: func(h *healthTracker) {
+				h.recordSuccess("gpt-4o", 300*time.Millisecond, 900*time.Millisecond)
+				h.recordSuccess("claude-3-haiku", 100*time.Millisecond, 400*time.Millisecond)
+				h.recordSuccess("gpt-4o-mini", 200*time.Millisecond, 500*time.Millisecond)
+				for i := 0; i < 5; i++ {
+					h.recordFailure("claude-3-haiku")
+				}
+			},
+			explore: 1,
+			want:    "gpt-4o-mini",
+		},
+		{
+			name: "only failures ranks last",
+			record: func(h *healthTracker) {
+				h.recordFailure("gpt-4o")
+				h.recordSuccess("claude-3-haiku", time.Second, 5*time.Second)
+				h.recordSuccess("gpt-4o-mini", time.Second, 6*time.Second)
+			},
+			explore: 1,
+			want:    "claude-3-haiku",
+		},
+		{
+			name: "exploration",
+			record: func(h *healthTracker) {
+				h.recordFailure("gpt-4o")
+				h.recordSuccess("claude-3-haiku", 100*time.Millisecond, 400*time.Millisecond)
+				h.recordSuccess("gpt-4o-mini", 200*time.Millisecond, 500*time.Millisecond)
+			},
+			explore: 0,
+			want:    "gpt-4o",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHealthTracker()
+			h.random = func() float64 { return tt.explore }
+			tt.record(h)
+
+			if got := h.pick(models); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAdaptivePolicyAvoidsFailingTarget(t *testing.T) {
+	mockOpenAI, seen := testutils.MockFlakyServer(100, http.StatusInternalServerError)
+	defer mockOpenAI.Close()
+
+	mockAnthropic := testutils.MockAnthropicServer()
+	defer mockAnthropic.Close()
+
+	keys := []config.APIKeyConfig{
+		{Key: "test-openai-key", Provider: "openai", MaxRPM: 60, MaxTPM: 40000},
+		{Key: "test-anthropic-key", Provider: "anthropic", MaxRPM: 60, MaxTPM: 40000},
+	}
+	rules := []config.ModelRule{
+		{SourceModel: "gpt-4", TargetModels: []string{"gpt-4o", "claude-3-haiku"}, SelectionPolicy: "adaptive"},
+	}
+	server := newRetryTestServer(t, mockOpenAI.URL, mockAnthropic.URL, keys, rules)
+	server.health.random = func() float64 { return 1 }
+
+	for i := 0; i < 5; i++ {
+		body := `{"model": "gpt-4", "messages": [{"role": "user", "content": "Hello ` + strings.Repeat("!", i) + `"}]}`
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.handleProxy(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected status 200, got %d: %s", i, w.Code, w.Body.String())
+		}
+	}
+
+	// Only the first request, before anything was known, went to the
+	// failing target
+	if len(*seen) != 1 {
+		t.Errorf("Expected 1 request to the failing target, got %d", len(*seen))
+	}
+}
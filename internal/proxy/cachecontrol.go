@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cachePolicy is how the response cache treats one request.
+type cachePolicy struct {
+	read   bool          // A cached response may be served
+	share  bool          // The response to an identical concurrent request may be shared
+	write  bool          // The response may be stored
+	ttl    time.Duration // How long the response is stored; zero for the cache's TTL
+	maxAge time.Duration // Age of the oldest cached response accepted; negative for any
+}
+
+// fresh reports whether a response cached at storedAt may be served.
+func (p cachePolicy) fresh(storedAt time.Time) bool {
+	return p.maxAge < 0 || time.Since(storedAt) <= p.maxAge
+}
+
+// cachePolicyFor combines the cache settings of req's rule with the
+// client's Cache-Control and X-Roxy-Cache-TTL headers. A request that could
+// be served a cached response can share a concurrent one; streamed
+// responses are shared but never cached.
+func (s *Server) cachePolicyFor(r *http.Request, req *LLMRequest) (cachePolicy, error) {
+	p := cachePolicy{read: true, write: true, maxAge: -1}
+
+	if rule := s.ruleFor(req.Model); rule != nil {
+		switch rule.Cache.Mode {
+		case "never":
+			p.read, p.write = false, false
+		case "deterministic":
+			if !req.deterministic() {
+				p.read, p.write = false, false
+			}
+		}
+		p.ttl = time.Duration(rule.Cache.TTLSec) * time.Second
+	}
+
+	for _, directive := range strings.Split(strings.Join(r.Header.Values("Cache-Control"), ","), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-cache":
+			p.read = false
+		case directive == "no-store":
+			p.read, p.write = false, false
+		case strings.HasPrefix(directive, "max-age="):
+			sec, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil || sec < 0 {
+				return p, fmt.Errorf("invalid Cache-Control: %s", directive)
+			}
+			p.maxAge = time.Duration(sec) * time.Second
+		}
+	}
+
+	if value := r.Header.Get("X-Roxy-Cache-TTL"); value != "" {
+		sec, err := strconv.Atoi(value)
+		if err != nil || sec < 0 {
+			return p, fmt.Errorf("invalid X-Roxy-Cache-TTL: %s", value)
+		}
+		if sec == 0 {
+			p.write = false
+		}
+		p.ttl = time.Duration(sec) * time.Second
+	}
+
+	p.share = p.read
+	if req.Stream {
+		p.read, p.write = false, false
+	}
+	return p, nil
+}
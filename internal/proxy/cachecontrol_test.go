@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CiaranMcAleer/roxy/internal/config"
+	"github.com/CiaranMcAleer/roxy/internal/testutils"
+)
+
+func TestCachePolicyFor(t *testing.T) {
+	rules := []config.ModelRule{
+		{SourceModel: "creative", TargetModels: config.TargetList{"gpt-4o"}, SelectionPolicy: "random", Cache: config.RuleCacheConfig{Mode: "deterministic", TTLSec: 60}},
+		{SourceModel: "live", TargetModels: config.TargetList{"gpt-4o"}, SelectionPolicy: "random", Cache: config.RuleCacheConfig{Mode: "never"}},
+	}
+
+	testCases := []struct {
+		name     string
+		body     string
+		headers  map[string]string
+		expected cachePolicy
+		err      bool
+	}{
+		{
+			name:     "default",
+			body:     `{"model":"gpt-4o"}`,
+			expected: cachePolicy{read: true, share: true, write: true, maxAge: -1},
+		},
+		{
+			name:     "stream",
+			body:     `{"model":"gpt-4o","stream":true}`,
+			expected: cachePolicy{share: true, maxAge: -1},
+		},
+		{
+			name:     "no-cache",
+			body:     `{"model":"gpt-4o"}`,
+			headers:  map[string]string{"Cache-Control": "No-Cache"},
+			expected: cachePolicy{write: true, maxAge: -1},
+		},
+		{
+			name:     "no-store",
+			body:     `{"model":"gpt-4o"}`,
+			headers:  map[string]string{"Cache-Control": "max-age=30, no-store"},
+			expected: cachePolicy{maxAge: 30 * time.Second},
+		},
+		{
+			name:     "max-age",
+			body:     `{"model":"gpt-4o"}`,
+			headers:  map[string]string{"Cache-Control": "max-age=30"},
+			expected: cachePolicy{read: true, share: true, write: true, maxAge: 30 * time.Second},
+		},
+		{
+			name:    "invalid max-age",
+			body:    `{"model":"gpt-4o"}`,
+			headers: map[string]string{"Cache-Control": "max-age=soon"},
+			err:     true,
+		},
+		{
+			name:     "ttl",
+			body:     `{"model":"gpt-4o"}`,
+			headers:  map[string]string{"X-Roxy-Cache-TTL": "3600"},
+			expected: cachePolicy{read: true, share: true, write: true, ttl: time.Hour, maxAge: -1},
+		},
+		{
+			name:     "zero ttl",
+			body:     `{"model":"gpt-4o"}`,
+			headers:  map[string]string{"X-Roxy-Cache-TTL": "0"},
+			expected: cachePolicy{read: true, share: true, maxAge: -1},
+		},
+		{
+			name:    "negative ttl",
+			body:    `{"model":"gpt-4o"}`,
+			headers: map[string]string{"X-Roxy-Cache-TTL": "-1"},
+			err:     true,
+		},
+		{
+			name:     "deterministic rule with temperature 0",
+			body:     `{"model":"creative","temperature":0}`,
+			expected: cachePolicy{read: true, share: true, write: true, ttl: time.Minute, maxAge: -1},
+		},
+		{
+			name:     "deterministic rule with seed",
+			body:     `{"model":"creative","temperature":1,"seed":42}`,
+			expected: cachePolicy{read: true, share: true, write: true, ttl: time.Minute, maxAge: -1},
+		},
+		{
+			name:     "deterministic rule with default temperature",
+			body:     `{"model":"creative"}`,
+			expected: cachePolicy{ttl: time.Minute, maxAge: -1},
+		},
+		{
+			name:     "deterministic rule with client ttl",
+			body:     `{"model":"creative","temperature":0}`,
+			headers:  map[string]string{"X-Roxy-Cache-TTL": "10"},
+			expected: cachePolicy{read: true, share: true, write: true, ttl: 10 * time.Second, maxAge: -1},
+		},
+		{
+			name:     "never cached rule",
+			body:     `{"model":"live","temperature":0}`,
+			expected: cachePolicy{maxAge: -1},
+		},
+	}
+
+	server := &Server{cfg: &config.Config{ModelRules: rules}}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := parseRequest([]byte(tc.body))
+			if err != nil {
+				t.Fatalf("Failed to parse request: %v", err)
+			}
+			r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+
+			policy, err := server.cachePolicyFor(r, req)
+			if tc.err {
+				if err == nil {
+					t.Error("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if policy != tc.expected {
+				t.Errorf("Expected %+v, got %+v", tc.expected, policy)
+			}
+		})
+	}
+}
+
+func TestCacheHeaders(t *testing.T) {
+	mockOpenAI, seen := testutils.MockFlakyServer(0, http.StatusInternalServerError)
+	defer mockOpenAI.Close()
+
+	keys := []config.APIKeyConfig{
+		{Key: "test-openai-key", Provider: "openai", MaxRPM: 60, MaxTPM: 40000},
+	}
+	server := newRetryTestServer(t, mockOpenAI.URL, "", keys, nil)
+	defer server.cache.Close()
+
+	send := func(content string, headers map[string]string) *httptest.ResponseRecorder {
+		body := `{"model": "gpt-4o", "messages": [{"role": "user", "content": "` + content + `"}]}`
+		r := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		server.handleProxy(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	testCases := []struct {
+		name     string
+		content  string
+		headers  map[string]string
+		expected string
+		requests int // Provider requests made so far
+	}{
+		{name: "first request", content: "a", expected: "MISS", requests: 1},
+		{name: "repeated request", content: "a", expected: "HIT", requests: 1},
+		{name: "no-cache", content: "a", headers: map[string]string{"Cache-Control": "no-cache"}, expected: "BYPASS", requests: 2},
+		{name: "no-store", content: "b", headers: map[string]string{"Cache-Control": "no-store"}, expected: "BYPASS", requests: 3},
+		{name: "after no-store", content: "b", expected: "MISS", requests: 4},
+		{name: "zero ttl", content: "c", headers: map[string]string{"X-Roxy-Cache-TTL": "0"}, expected: "MISS", requests: 5},
+		{name: "after zero ttl", content: "c", expected: "MISS", requests: 6},
+		{name: "after zero ttl again", content: "c", expected: "HIT", requests: 6},
+	}
+
+	for _, tc := range testCases {
+		w := send(tc.content, tc.headers)
+		if got := w.Header().Get("X-Roxy-Cache"); got != tc.expected {
+			t.Errorf("%s: expected X-Roxy-Cache %s, got %s", tc.name, tc.expected, got)
+		}
+		if tc.expected == "HIT" && w.Header().Get("Age") != "0" {
+			t.Errorf("%s: expected Age 0, got %q", tc.name, w.Header().Get("Age"))
+		}
+		if len(*seen) != tc.requests {
+			t.Errorf("%s: expected %d provider requests, got %d", tc.name, tc.requests, len(*seen))
+		}
+	}
+
+	// A rule that never caches bypasses the cache too
+	rules := []config.ModelRule{
+		{SourceModel: "live", TargetModels: config.TargetList{"gpt-4o"}, SelectionPolicy: "random", Cache: config.RuleCacheConfig{Mode: "never"}},
+	}
+	live := newRetryTestServer(t, mockOpenAI.URL, "", keys, rules)
+	defer live.cache.Close()
+	if w := sendChat(live, "live"); w.Header().Get("X-Roxy-Cache") != "BYPASS" {
+		t.Errorf("Expected X-Roxy-Cache BYPASS under a never rule, got %s", w.Header().Get("X-Roxy-Cache"))
+	}
+
+	r := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model": "gpt-4o", "messages": []}`))
+	r.Header.Set("X-Roxy-Cache-TTL", "soon")
+	w := httptest.NewRecorder()
+	server.handleProxy(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid TTL, got %d", w.Code)
+	}
+}
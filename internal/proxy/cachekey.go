@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// uncachedFields are request fields that don't change the response, so
+// requests differing only in them share a cache entry. The model is
+// replaced by the one the key policy names.
+var uncachedFields = []string{"model", "stream", "stream_options", "user", "metadata", "store"}
+
+// cacheKey returns the key req's response is cached under when model
+// answers it: a hash of the client's format, its namespace, the model
+// named by the cache's key_model and the canonical form of the request.
+func (s *Server) cacheKey(r *http.Request, req *LLMRequest, model string) string {
+	if s.cfg.Cache.KeyModel == "target" {
+		provider, name, _ := s.cfg.ProviderFor(model)
+		model = provider + "/" + name
+	} else {
+		model = req.Model
+	}
+
+	// Encoding the parts as a JSON array keeps their boundaries unambiguous
+	key, _ := json.Marshal([]any{req.format.String(), s.cacheNamespace(r, req), model, canonicalRequest(req)})
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheNamespace identifies whose cached responses req may be served, as
+// configured by the cache's namespace_by.
+func (s *Server) cacheNamespace(r *http.Request, req *LLMRequest) string {
+	by := s.cfg.Cache.NamespaceBy
+	switch by {
+	case "none":
+		return ""
+	case "user":
+		return "user:" + req.User
+	case "", "key":
+		key := r.Header.Get("Authorization")
+		if key == "" {
+			key = r.Header.Get("X-Api-Key")
+		}
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(sum[:])
+	}
+	name, _ := strings.CutPrefix(by, "header:")
+	return "header:" + r.Header.Get(name)
+}
+
+// canonicalRequest returns req's body in the client's own format with the
+// fields that don't affect the response removed. Once marshalled its
+// object keys are sorted and its numbers spelled one way, so requests that
+// mean the same thing encode the same.
+func canonicalRequest(req *LLMRequest) any {
+	body := req.body
+	if req.native != nil {
+		body = req.native
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return string(body)
+	}
+	if fields, ok := doc.(map[string]any); ok {
+		for _, field := range uncachedFields {
+			delete(fields, field)
+		}
+	}
+	return canonicalValue(doc)
+}
+
+func canonicalValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, item := range v {
+			v[k] = canonicalValue(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = canonicalValue(item)
+		}
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+	}
+	return v
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CiaranMcAleer/roxy/internal/config"
+)
+
+func TestCacheKey(t *testing.T) {
+	const hello = `{"model":"gpt-4o","messages":[{"role":"user","content":"Hello"}],"temperature":1}`
+
+	type request struct {
+		body   string
+		auth   string
+		target string // Model that answers; the requested one if empty
+	}
+	testCases := []struct {
+		name  string
+		cache config.CacheConfig
+		a, b  request
+		same  bool
+	}{
+		{
+			name: "field order, spacing and number spelling",
+			a:    request{body: hello},
+			b:    request{body: `{ "temperature": 1.0, "messages": [{"content": "Hello", "role": "user"}], "model": "gpt-4o" }`},
+			same: true,
+		},
+		{
+			name: "stream and user",
+			a:    request{body: hello},
+			b:    request{body: `{"model":"gpt-4o","messages":[{"role":"user","content":"Hello"}],"temperature":1,"stream":true,"user":"u1"}`},
+			same: true,
+		},
+		{
+			name: "message boundaries",
+			a:    request{body: `{"model":"gpt-4o","messages":[{"role":"user","content":"ab"},{"role":"user","content":"c"}]}`},
+			b:    request{body: `{"model":"gpt-4o","messages":[{"role":"user","content":"a"},{"role":"user","content":"bc"}]}`},
+		},
+		{
+			name: "tools",
+			a:    request{body: hello},
+			b:    request{body: `{"model":"gpt-4o","messages":[{"role":"user","content":"Hello"}],"temperature":1,"tools":[{"type":"function","function":{"name":"f"}}]}`},
+		},
+		{
+			name: "top_p",
+			a:    request{body: hello},
+			b:    request{body: `{"model":"gpt-4o","messages":[{"role":"user","content":"Hello"}],"temperature":1,"top_p":0.5}`},
+		},
+		{
+			name: "stop",
+			a:    request{body: hello},
+			b:    request{body: `{"model":"gpt-4o","messages":[{"role":"user","content":"Hello"}],"temperature":1,"stop":["\n"]}`},
+		},
+		{
+			name: "response_format",
+			a:    request{body: hello},
+			b:    request{body: `{"model":"gpt-4o","messages":[{"role":"user","content":"Hello"}],"temperature":1,"response_format":{"type":"json_object"}}`},
+		},
+		{
+			name: "prompt",
+			a:    request{body: `{"model":"gpt-4o","prompt":"Hello"}`},
+			b:    request{body: `{"model":"gpt-4o","prompt":"Goodbye"}`},
+		},
+		{
+			name: "api keys",
+			a:    request{body: hello, auth: "Bearer client-1"},
+			b:    request{body: hello, auth: "Bearer client-2"},
+		},
+		{
+			name:  "api keys without namespaces",
+			cache: config.CacheConfig{NamespaceBy: "none"},
+			a:     request{body: hello, auth: "Bearer client-1"},
+			b:     request{body: hello, auth: "Bearer client-2"},
+			same:  true,
+		},
+		{
+			name: "source models answered by one target",
+			a:    request{body: hello, target: "gpt-4o-mini"},
+			b:    request{body: strings.Replace(hello, "gpt-4o", "fast", 1), target: "gpt-4o-mini"},
+		},
+		{
+			name:  "source models answered by one target keyed on the target",
+			cache: config.CacheConfig{KeyModel: "target"},
+			a:     request{body: hello, target: "gpt-4o-mini"},
+			b:     request{body: strings.Replace(hello, "gpt-4o", "fast", 1), target: "openai/gpt-4o-mini"},
+			same:  true,
+		},
+		{
+			name:  "targets keyed on the target",
+			cache: config.CacheConfig{KeyModel: "target"},
+			a:     request{body: hello, target: "gpt-4o"},
+			b:     request{body: hello, target: "gpt-4o-mini"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := &Server{cfg: &config.Config{Cache: tc.cache}}
+
+			key := func(r request) string {
+				req, err := parseRequest([]byte(r.body))
+				if err != nil {
+					t.Fatalf("Failed to parse request: %v", err)
+				}
+				httpReq := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+				if r.auth != "" {
+					httpReq.Header.Set("Authorization", r.auth)
+				}
+				target := r.target
+				if target == "" {
+					target = req.Model
+				}
+				return server.cacheKey(httpReq, req, target)
+			}
+
+			if same := key(tc.a) == key(tc.b); same != tc.same {
+				t.Errorf("Expected same key %v, got %v", tc.same, same)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package proxy
+
+import "sort"
+
+// cheapest returns the models that can serve req, cheapest first. A model
+// qualifies if the catalogue lists every capability req relies on and the
+// prompt plus completion limit fits in its context window. The cost is
+// estimated from the catalogue prices and req's token estimates.
+func (s *Server) cheapest(models []string, req *LLMRequest) []string {
+	prompt, completion := req.promptTokens(), req.completionTokens()
+	capabilities := req.capabilities()
+
+	type pricedModel struct {
+		name string
+		cost float64
+	}
+	var fits []pricedModel
+	for _, model := range models {
+		info, ok := s.cfg.CatalogueEntry(s.catalogue, model)
+		if !ok || !info.Supports(capabilities) {
+			continue
+		}
+		if info.ContextWindow > 0 && prompt+completion > info.ContextWindow {
+			continue
+		}
+		cost := float64(prompt)*info.InputPrice + float64(completion)*info.OutputPrice
+		fits = append(fits, pricedModel{name: model, cost: cost})
+	}
+
+	// Equally priced models keep the rule's order
+	sort.SliceStable(fits, func(i, j int) bool { return fits[i].cost < fits[j].cost })
+
+	names := make([]string, len(fits))
+	for i, m := range fits {
+		names[i] = m.name
+	}
+	return names
+}
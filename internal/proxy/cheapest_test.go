@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/CiaranMcAleer/roxy/internal/config"
+	"github.com/CiaranMcAleer/roxy/internal/testutils"
+)
+
+var testCatalogue = []config.ModelInfo{
+	{Name: "gpt-4o", InputPrice: 0.0000025, OutputPrice: 0.00001, ContextWindow: 128000, Capabilities: []string{"tools", "vision", "json_mode"}},
+	{Name: "gpt-4o-mini", InputPrice: 0.00000015, OutputPrice: 0.0000006, ContextWindow: 128000, Capabilities: []string{"tools", "json_mode"}},
+	{Name: "claude-3-haiku", InputPrice: 0.00000025, OutputPrice: 0.00000125, ContextWindow: 2000, Capabilities: []string{"tools", "vision"}},
+}
+
+func TestCheapest(t *testing.T) {
+	cfg := &config.Config{Models: testCatalogue}
+	server := &Server{catalogue: cfg.Catalogue()}
+	targets := []string{"gpt-4o", "claude-3-haiku", "gpt-4o-mini"}
+
+	testCases := []struct {
+		name     string
+		body     string
+		expected []string
+	}{
+		{
+			name:     "plain request",
+			body:     `{"model": "gpt-4", "messages": [{"role": "user", "content": "Hello"}]}`,
+			expected: []string{"gpt-4o-mini", "claude-3-haiku", "gpt-4o"},
+		},
+		{
+			name:     "tools",
+			body:     `{"model": "gpt-4", "messages": [{"role": "user", "content": "Hello"}], "tools": [{"type": "function", "function": {"name": "lookup"}}]}`,
+			expected: []string{"gpt-4o-mini", "claude-3-haiku", "gpt-4o"},
+		},
+		{
+			name:     "vision",
+			body:     `{"model": "gpt-4", "messages": [{"role": "user", "content": [{"type": "text", "text": "What is this?"}, {"type": "image_url", "image_url": {"url": "https://example.com/cat.png"}}]}]}`,
+			expected: []string{"claude-3-haiku", "gpt-4o"},
+		},
+		{
+			name:     "json mode",
+			body:     `{"model": "gpt-4", "messages": [{"role": "user", "content": "Hello"}], "response_format": {"type": "json_object"}}`,
+			expected: []string{"gpt-4o-mini", "gpt-4o"},
+		},
+		{
+			name:     "vision and json mode",
+			body:     `{"model": "gpt-4", "messages": [{"role": "user", "content": [{"type": "image_url", "image_url": {"url": "https://example.com/cat.png"}}]}], "response_format": {"type": "json_object"}}`,
+			expected: []string{"gpt-4o"},
+		},
+		{
+			name:     "completion exceeds small context window",
+			body:     `{"model": "gpt-4", "messages": [{"role": "user", "content": "Hello"}], "max_tokens": 4000}`,
+			expected: []string{"gpt-4o-mini", "gpt-4o"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := parseRequest([]byte(tc.body))
+			if err != nil {
+				t.Fatalf("Failed to parse request: %v", err)
+			}
+
+			got := server.cheapest(targets, req)
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestCheapestPolicy(t *testing.T) {
+	mockOpenAI, _ := testutils.MockFlakyServer(0, http.StatusInternalServerError)
+	defer mockOpenAI.Close()
+
+	keys := []config.APIKeyConfig{
+		{Key: "test-openai-key", Provider: "openai", MaxRPM: 60, MaxTPM: 40000},
+	}
+	rules := []config.ModelRule{
+		{SourceModel: "gpt-4", TargetModels: []string{"gpt-4o", "gpt-4o-mini"}, SelectionPolicy: "cheapest"},
+	}
+	models := []config.ModelInfo{
+		{Name: "gpt-4o", InputPrice: 0.0000025, OutputPrice: 0.00001, ContextWindow: 128000, Capabilities: []string{"tools", "json_mode"}},
+		{Name: "gpt-4o-mini", InputPrice: 0.00000015, OutputPrice: 0.0000006, ContextWindow: 128000, Capabilities: []string{"tools"}},
+	}
+	cfg := &config.Config{
+		ListenAddr: ":8080",
+		APIKeys:    keys,
+		ModelRules: rules,
+		Models:     models,
+	}
+	cfg.Providers.OpenAI.BaseURL = mockOpenAI.URL
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	testCases := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedModel  string
+	}{
+		{
+			name:           "cheapest target",
+			body:           `{"model": "gpt-4", "messages": [{"role": "user", "content": "Hello"}]}`,
+			expectedStatus: http.StatusOK,
+			expectedModel:  "gpt-4o-mini",
+		},
+		{
+			name:           "only target with json mode",
+			body:           `{"model": "gpt-4", "messages": [{"role": "user", "content": "Reply in JSON"}], "response_format": {"type": "json_object"}}`,
+			expectedStatus: http.StatusOK,
+			expectedModel:  "gpt-4o",
+		},
+		{
+			name:           "no target fits",
+			body:           `{"model": "gpt-4", "messages": [{"role": "user", "content": "Hello"}], "max_tokens": 200000}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			server.handleProxy(w, req)
+
+			if w.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tc.expectedStatus, w.Code, w.Body.String())
+			}
+			if tc.expectedModel == "" {
+				return
+			}
+
+			var resp struct {
+				Model string `json:"model"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp.Model != tc.expectedModel {
+				t.Errorf("Expected model %s, got %s", tc.expectedModel, resp.Model)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// flight is one upstream request whose response is relayed to every
+// identical request that arrives while it is under way. The response is
+// written into the flight, which buffers it, and each client is sent the
+// buffer from the start as it grows, so a client that joins part way
+// through a stream still gets all of it.
+type flight struct {
+	mu      sync.Mutex
+	header  http.Header // Written to by the request until it sends its status
+	sent    http.Header // The header as it was when the status was sent
+	status  int
+	body    []byte
+	done    bool
+	changed chan struct{} // Closed and replaced whenever any of the above change
+
+	// clients counts the requests relaying the flight; once all of them
+	// have gone the upstream request is cancelled, and no more can join.
+	clients   int
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+func newFlight(cancel context.CancelFunc) *flight {
+	return &flight{
+		header:  make(http.Header),
+		changed: make(chan struct{}),
+		cancel:  cancel,
+	}
+}
+
+// notify wakes the clients waiting for the flight to change. It is called
+// with the lock held.
+func (f *flight) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *flight) Header() http.Header {
+	return f.header
+}
+
+func (f *flight) WriteHeader(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.status != 0 {
+		return
+	}
+	f.status, f.sent = status, f.header.Clone()
+	f.notify()
+}
+
+func (f *flight) Write(p []byte) (int, error) {
+	f.WriteHeader(http.StatusOK)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.body = append(f.body, p...)
+	f.notify()
+	return len(p), nil
+}
+
+// Flush is a no-op: every write is relayed as soon as it is made.
+func (f *flight) Flush() {}
+
+func (f *flight) finish() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.done = true
+	f.notify()
+}
+
+// join adds a client to the flight, reporting false if its upstream
+// request has been cancelled.
+func (f *flight) join() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cancelled {
+		return false
+	}
+	f.clients++
+	return true
+}
+
+// leave removes a client from the flight, cancelling the upstream request
+// if it was the last one and the response isn't complete.
+func (f *flight) leave() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.clients--
+	if f.clients == 0 && !f.done {
+		f.cancelled = true
+		f.cancel()
+	}
+}
+
+// relay writes the flight's response to w until it is complete or ctx, the
+// client's context, is done.
+func (f *flight) relay(ctx context.Context, w http.ResponseWriter) {
+	flusher, _ := w.(http.Flusher)
+
+	var sentHeader bool
+	var offset int
+	for {
+		f.mu.Lock()
+		status, header, chunk, done, changed := f.status, f.sent, f.body[offset:], f.done, f.changed
+		f.mu.Unlock()
+
+		wrote := false
+		if !sentHeader && status != 0 {
+			for k, vv := range header {
+				w.Header()[k] = vv
+			}
+			w.WriteHeader(status)
+			sentHeader, wrote = true, true
+		}
+		if len(chunk) > 0 {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			offset += len(chunk)
+			wrote = true
+		}
+		if flusher != nil && wrote {
+			flusher.Flush()
+		}
+		if done {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// coalesce serves r with the response to an identical request already
+// under way, if there is one, and otherwise makes the request with fetch
+// on behalf of r and every identical request that arrives meanwhile. The
+// upstream request outlives any one client, and is cancelled only once
+// every client has gone. A cancelled request is replaced by a new one
+// rather than joined.
+func (s *Server) coalesce(w http.ResponseWriter, r *http.Request, key string, fetch func(http.ResponseWriter, *http.Request)) {
+	s.flightsMu.Lock()
+	f, exists := s.flights[key]
+	if !exists || !f.join() {
+		ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+		f = newFlight(cancel)
+		for k, vv := range w.Header() {
+			f.header[k] = vv
+		}
+		s.flights[key] = f
+		f.join()
+
+		s.flightsWG.Add(1)
+		go func(f *flight) {
+			defer s.flightsWG.Done()
+			defer cancel()
+			fetch(f, r.WithContext(ctx))
+
+			s.flightsMu.Lock()
+			if s.flights[key] == f {
+				delete(s.flights, key)
+			}
+			s.flightsMu.Unlock()
+			f.finish()
+		}(f)
+	}
+	s.flightsMu.Unlock()
+
+	defer f.leave()
+	f.relay(r.Context(), w)
+}
@@ -0,0 +1,288 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CiaranMcAleer/roxy/internal/config"
+	"github.com/CiaranMcAleer/roxy/internal/testutils"
+)
+
+func newCoalesceTestServer(t *testing.T, gate chan struct{}) (*Server, func() int) {
+	t.Helper()
+
+	mock, requests := testutils.MockGatedServer(gate)
+	t.Cleanup(mock.Close)
+
+	keys := []config.APIKeyConfig{
+		{Key: "test-openai-key", Provider: "openai", MaxRPM: 600, MaxTPM: 400000},
+	}
+	server := newRetryTestServer(t, mock.URL, "", keys, nil)
+	t.Cleanup(func() { server.cache.Close() })
+	return server, requests
+}
+
+// waitFor polls until cond holds, failing the test if it doesn't within a
+// couple of seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// flightClients counts the clients relaying the server's flights.
+func flightClients(server *Server) int {
+	server.flightsMu.Lock()
+	defer server.flightsMu.Unlock()
+
+	clients := 0
+	for _, f := range server.flights {
+		f.mu.Lock()
+		clients += f.clients
+		f.mu.Unlock()
+	}
+	return clients
+}
+
+func sendConcurrently(server *Server, body string, headers map[string]string, n int) ([]*httptest.ResponseRecorder, *sync.WaitGroup) {
+	responses := make([]*httptest.ResponseRecorder, n)
+	var wg sync.WaitGroup
+	for i := range responses {
+		responses[i] = httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+
+		wg.Add(1)
+		go func(w *httptest.ResponseRecorder) {
+			defer wg.Done()
+			server.handleProxy(w, r)
+		}(responses[i])
+	}
+	return responses, &wg
+}
+
+func TestCoalescing(t *testing.T) {
+	gate := make(chan struct{})
+	server, requests := newCoalesceTestServer(t, gate)
+
+	body := `{"model": "gpt-4o", "messages": [{"role": "user", "content": "Hello"}]}`
+	responses, wg := sendConcurrently(server, body, nil, 5)
+	waitFor(t, "every request to join the flight", func() bool { return flightClients(server) == 5 })
+	close(gate)
+	wg.Wait()
+
+	if n := requests(); n != 1 {
+		t.Errorf("Expected 1 provider request, got %d", n)
+	}
+	for i, w := range responses {
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "gpt-4o") {
+			t.Errorf("Request %d: expected the shared response, got %d: %s", i, w.Code, w.Body.String())
+		}
+		if w.Body.String() != responses[0].Body.String() {
+			t.Errorf("Request %d: expected the same response as the first, got %s", i, w.Body.String())
+		}
+		if w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("Request %d: expected the provider's headers, got %v", i, w.Header())
+		}
+	}
+
+	if w := sendChat(server, "gpt-4o"); w.Header().Get("X-Roxy-Cache") != "HIT" || requests() != 1 {
+		t.Errorf("Expected the shared response to have been cached, got %s after %d provider requests", w.Header().Get("X-Roxy-Cache"), requests())
+	}
+}
+
+func TestCoalescedStream(t *testing.T) {
+	gate := make(chan struct{})
+	server, requests := newCoalesceTestServer(t, gate)
+
+	body := `{"model": "gpt-4o", "stream": true, "messages": [{"role": "user", "content": "Hello"}]}`
+	first, wg := sendConcurrently(server, body, nil, 1)
+	waitFor(t, "the stream to start", func() bool { return requests() == 1 && flightClients(server) == 1 })
+
+	// A client joining part way through gets the stream from the start
+	late, lateWG := sendConcurrently(server, body, nil, 1)
+	waitFor(t, "the second client to join", func() bool { return flightClients(server) == 2 })
+	close(gate)
+	wg.Wait()
+	lateWG.Wait()
+
+	if n := requests(); n != 1 {
+		t.Errorf("Expected 1 provider request, got %d", n)
+	}
+	for i, w := range append(first, late...) {
+		out := w.Body.String()
+		if !strings.Contains(out, `"first"`) || !strings.Contains(out, `" second"`) || !strings.Contains(out, "[DONE]") {
+			t.Errorf("Client %d: expected the whole stream, got %q", i, out)
+		}
+		if w.Header().Get("Content-Type") != "text/event-stream" {
+			t.Errorf("Client %d: expected an event stream, got %v", i, w.Header())
+		}
+	}
+	if first[0].Body.String() != late[0].Body.String() {
+		t.Errorf("Expected both clients to get the same stream, got %q and %q", first[0].Body.String(), late[0].Body.String())
+	}
+}
+
+func TestCoalescingOnlySharesWhatMayBeShared(t *testing.T) {
+	gate := make(chan struct{})
+	server, requests := newCoalesceTestServer(t, gate)
+
+	body := `{"model": "gpt-4o", "messages": [{"role": "user", "content": "Hello"}]}`
+	_, wg := sendConcurrently(server, body, map[string]string{"Cache-Control": "no-cache"}, 2)
+	waitFor(t, "both requests to reach the provider", func() bool { return requests() == 2 })
+
+	// Requests from other clients, and streams, have flights of their own
+	_, otherWG := sendConcurrently(server, body, map[string]string{"Authorization": "Bearer other-client"}, 1)
+	_, streamWG := sendConcurrently(server, strings.Replace(body, `"messages"`, `"stream": true, "messages"`, 1), nil, 1)
+	_, sharedWG := sendConcurrently(server, body, nil, 1)
+	waitFor(t, "every request to reach the provider", func() bool { return requests() == 5 })
+
+	close(gate)
+	wg.Wait()
+	otherWG.Wait()
+	streamWG.Wait()
+	sharedWG.Wait()
+}
+
+func TestCoalescedRequestCancelledWithLastClient(t *testing.T) {
+	gate := make(chan struct{})
+	defer close(gate)
+	server, _ := newCoalesceTestServer(t, gate)
+
+	body := `{"model": "gpt-4o", "messages": [{"role": "user", "content": "Hello"}]}`
+	var wg sync.WaitGroup
+	cancels := make([]context.CancelFunc, 2)
+	for i := range cancels {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancels[i] = cancel
+		r := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body)).WithContext(ctx)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			server.handleProxy(httptest.NewRecorder(), r)
+		}()
+	}
+	waitFor(t, "both requests to join the flight", func() bool { return flightClients(server) == 2 })
+
+	cancels[0]()
+	waitFor(t, "the first client to leave", func() bool { return flightClients(server) == 1 })
+	server.flightsMu.Lock()
+	remaining := len(server.flights)
+	server.flightsMu.Unlock()
+	if remaining != 1 {
+		t.Errorf("Expected the flight to continue for the remaining client, got %d flights", remaining)
+	}
+
+	cancels[1]()
+	wg.Wait()
+	waitFor(t, "the upstream request to be cancelled", func() bool {
+		server.flightsMu.Lock()
+		defer server.flightsMu.Unlock()
+		return len(server.flights) == 0
+	})
+}
+
+func TestCancelledFlightIsNotJoined(t *testing.T) {
+	server, _ := newCoalesceTestServer(t, make(chan struct{}))
+
+	// The first flight's request outlives its only client
+	release := make(chan struct{})
+	defer close(release)
+	ctx, cancel := context.WithCancel(context.Background())
+	left := make(chan struct{})
+	go func() {
+		defer close(left)
+		r := httptest.NewRequest("POST", "/v1/chat/completions", nil).WithContext(ctx)
+		server.coalesce(httptest.NewRecorder(), r, "key", func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			http.Error(w, "Provider request failed", http.StatusBadGateway)
+		})
+	}()
+	waitFor(t, "the first client to join", func() bool { return flightClients(server) == 1 })
+	cancel()
+	<-left
+
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.coalesce(w, httptest.NewRequest("POST", "/v1/chat/completions", nil), "key", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("fresh"))
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the second request; it joined the cancelled flight")
+	}
+	if w.Code != http.StatusOK || w.Body.String() != "fresh" {
+		t.Errorf("Expected a response from a new flight, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCoalescingKeepsEachClientsCachePolicy(t *testing.T) {
+	gate := make(chan struct{})
+	server, requests := newCoalesceTestServer(t, gate)
+	var open sync.Once
+	t.Cleanup(func() { open.Do(func() { close(gate) }) })
+
+	// A request that mustn't be cached doesn't lead one that may be
+	body := `{"model": "gpt-4o", "messages": [{"role": "user", "content": "Hello"}]}`
+	_, uncachedWG := sendConcurrently(server, body, map[string]string{"X-Roxy-Cache-TTL": "0"}, 1)
+	waitFor(t, "the uncached request to reach the provider", func() bool { return requests() == 1 })
+	_, cachedWG := sendConcurrently(server, body, nil, 1)
+	waitFor(t, "the cached request to reach the provider", func() bool { return requests() == 2 })
+
+	open.Do(func() { close(gate) })
+	uncachedWG.Wait()
+	cachedWG.Wait()
+
+	if w := sendChat(server, "gpt-4o"); w.Header().Get("X-Roxy-Cache") != "HIT" {
+		t.Errorf("Expected the second response to have been cached, got %s", w.Header().Get("X-Roxy-Cache"))
+	}
+}
+
+func TestShutdownWaitsForFlights(t *testing.T) {
+	server, _ := newCoalesceTestServer(t, make(chan struct{}))
+
+	// The flight's only client leaves before its request is over
+	release := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest("POST", "/v1/chat/completions", nil).WithContext(ctx)
+	server.coalesce(httptest.NewRecorder(), r, "key", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.Shutdown()
+	}()
+	select {
+	case <-done:
+		t.Fatal("Expected shutdown to wait for the flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for shutdown")
+	}
+}
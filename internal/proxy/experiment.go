@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CiaranMcAleer/roxy/internal/config"
+	"github.com/CiaranMcAleer/roxy/internal/provider"
+)
+
+// weightedTarget picks one of rule's targets in proportion to its weight.
+// Requests with the same non-empty sticky ID always get the same target
+// for as long as the weights don't change.
+func weightedTarget(rule *config.ModelRule, sticky string) string {
+	total := 0
+	for _, model := range rule.TargetModels {
+		total += rule.TargetWeight(model)
+	}
+	if total <= 0 {
+		return rule.TargetModels[0]
+	}
+
+	var point int
+	if sticky != "" {
+		h := fnv.New64a()
+		io.WriteString(h, rule.SourceModel+"\x00"+sticky)
+		point = int(h.Sum64() % uint64(total))
+	} else {
+		point = rand.Intn(total)
+	}
+
+	for _, model := range rule.TargetModels {
+		weight := rule.TargetWeight(model)
+		if point < weight {
+			return model
+		}
+		point -= weight
+	}
+	return rule.TargetModels[0]
+}
+
+// stickyID returns the request attribute rule assigns targets by, or ""
+// if the rule isn't sticky or the request doesn't carry it.
+func stickyID(rule *config.ModelRule, r *http.Request, req *LLMRequest) string {
+	if rule.StickyBy == "user" {
+		return req.User
+	}
+	if name, ok := strings.CutPrefix(rule.StickyBy, "header:"); ok {
+		return r.Header.Get(name)
+	}
+	return ""
+}
+
+// armStats accumulates how one target of a rule has performed.
+type armStats struct {
+	requests         int
+	errors           int
+	latency          time.Duration // Summed over successful requests
+	firstToken       time.Duration // Summed over successful requests
+	promptTokens     int
+	completionTokens int
+}
+
+// armMetrics records per-target outcomes of every model rule, so that the
+// arms of an experiment can be compared.
+type armMetrics struct {
+	mu   sync.Mutex
+	arms map[string]map[string]*armStats
+}
+
+func newArmMetrics() *armMetrics {
+	return &armMetrics{arms: make(map[string]map[string]*armStats)}
+}
+
+func (m *armMetrics) arm(sourceModel, model string) *armStats {
+	rule, ok := m.arms[sourceModel]
+	if !ok {
+		rule = make(map[string]*armStats)
+		m.arms[sourceModel] = rule
+	}
+	stats, ok := rule[model]
+	if !ok {
+		stats = &armStats{}
+		rule[model] = stats
+	}
+	return stats
+}
+
+func (m *armMetrics) recordSuccess(sourceModel, model string, firstToken, latency time.Duration, usage provider.Usage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.arm(sourceModel, model)
+	stats.requests++
+	stats.latency += latency
+	stats.firstToken += firstToken
+	stats.promptTokens += usage.PromptTokens
+	stats.completionTokens += usage.CompletionTokens
+}
+
+func (m *armMetrics) recordFailure(sourceModel, model string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.arm(sourceModel, model)
+	stats.requests++
+	stats.errors++
+}
+
+// write lists every arm's metrics, one line per rule and target.
+func (m *armMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sources := make([]string, 0, len(m.arms))
+	for source := range m.arms {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	for _, source := range sources {
+		models := make([]string, 0, len(m.arms[source]))
+		for model := range m.arms[source] {
+			models = append(models, model)
+		}
+		sort.Strings(models)
+
+		for _, model := range models {
+			stats := m.arms[source][model]
+			var avgLatency, avgFirstToken time.Duration
+			if ok := stats.requests - stats.errors; ok > 0 {
+				avgLatency = stats.latency / time.Duration(ok)
+				avgFirstToken = stats.firstToken / time.Duration(ok)
+			}
+			fmt.Fprintf(w, "Rule: %s, Model: %s, Requests: %d, Errors: %d (%.1f%%), Avg latency: %s, Avg first token: %s, Prompt tokens: %d, Completion tokens: %d\n",
+				source, model, stats.requests, stats.errors, 100*float64(stats.errors)/float64(stats.requests),
+				avgLatency.Round(time.Millisecond), avgFirstToken.Round(time.Millisecond),
+				stats.promptTokens, stats.completionTokens)
+		}
+	}
+}
+
+// recordSuccess notes a's successful response against its target's health
+// and, for requests routed by a model rule, the rule's arm.
+func (s *Server) recordSuccess(req *LLMRequest, a *attempt, firstToken time.Duration, usage provider.Usage) {
+	latency := time.Since(a.start)
+	s.health.recordSuccess(a.model, firstToken, latency)
+	if rule := s.ruleFor(req.Model); rule != nil {
+		s.arms.recordSuccess(rule.SourceModel, a.model, firstToken, latency, usage)
+	}
+}
+
+// recordFailure notes a failed attempt the same way.
+func (s *Server) recordFailure(req *LLMRequest, a *attempt) {
+	s.health.recordFailure(a.model)
+	if rule := s.ruleFor(req.Model); rule != nil {
+		s.arms.recordFailure(rule.SourceModel, a.model)
+	}
+}
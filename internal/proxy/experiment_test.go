@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CiaranMcAleer/roxy/internal/config"
+	"github.com/CiaranMcAleer/roxy/internal/testutils"
+)
+
+func TestWeightedTarget(t *testing.T) {
+	rule := &config.ModelRule{
+		SourceModel:  "gpt-4",
+		TargetModels: []string{"gpt-4o", "gpt-4.1", "gpt-4o-mini"},
+		Weights:      map[string]int{"gpt-4o": 90, "gpt-4.1": 10},
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[weightedTarget(rule, "")]++
+	}
+	if counts["gpt-4o"] < 8700 || counts["gpt-4o"] > 9300 {
+		t.Errorf("Expected about 9000 requests to gpt-4o, got %d", counts["gpt-4o"])
+	}
+	if counts["gpt-4.1"] < 700 || counts["gpt-4.1"] > 1300 {
+		t.Errorf("Expected about 1000 requests to gpt-4.1, got %d", counts["gpt-4.1"])
+	}
+	if counts["gpt-4o-mini"] != 0 {
+		t.Errorf("Expected no requests to unweighted gpt-4o-mini, got %d", counts["gpt-4o-mini"])
+	}
+
+	// A sticky ID always lands on the same target, and IDs are spread
+	// across targets by weight
+	sticky := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("user-%d", i)
+		model := weightedTarget(rule, id)
+		for j := 0; j < 3; j++ {
+			if again := weightedTarget(rule, id); again != model {
+				t.Fatalf("Expected %s to stay on %s, got %s", id, model, again)
+			}
+		}
+		sticky[model]++
+	}
+	if sticky["gpt-4.1"] < 50 || sticky["gpt-4.1"] > 150 {
+		t.Errorf("Expected about 100 users on gpt-4.1, got %d", sticky["gpt-4.1"])
+	}
+
+	// Without weights every target gets an equal share
+	rule.Weights = nil
+	counts = make(map[string]int)
+	for i := 0; i < 9000; i++ {
+		counts[weightedTarget(rule, "")]++
+	}
+	for _, model := range rule.TargetModels {
+		if counts[model] < 2700 || counts[model] > 3300 {
+			t.Errorf("Expected about 3000 requests to %s, got %d", model, counts[model])
+		}
+	}
+}
+
+func TestWeightedPolicyIsStickyAndRecordsArms(t *testing.T) {
+	mockOpenAI, _ := testutils.MockFlakyServer(0, http.StatusInternalServerError)
+	defer mockOpenAI.Close()
+
+	keys := []config.APIKeyConfig{
+		{Key: "test-openai-key", Provider: "openai", MaxRPM: 600, MaxTPM: 400000},
+	}
+	rules := []config.ModelRule{
+		{
+			SourceModel:     "gpt-4",
+			TargetModels:    []string{"gpt-4o", "gpt-4.1"},
+			SelectionPolicy: "weighted",
+			Weights:         map[string]int{"gpt-4o": 50, "gpt-4.1": 50},
+			StickyBy:        "header:X-User-ID",
+		},
+	}
+	server := newRetryTestServer(t, mockOpenAI.URL, "", keys, rules)
+
+	assigned := make(map[string]string)
+	for _, user := range []string{"alice", "bob", "carol", "dave"} {
+		for i := 0; i < 3; i++ {
+			body := fmt.Sprintf(`{"model": "gpt-4", "messages": [{"role": "user", "content": "Hello from %s %d"}]}`, user, i)
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+			req.Header.Set("X-User-ID", user)
+			w := httptest.NewRecorder()
+			server.handleProxy(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+			var resp struct {
+				Model string `json:"model"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+
+			if prev, ok := assigned[user]; ok && prev != resp.Model {
+				t.Errorf("Expected %s to stay on %s, got %s", user, prev, resp.Model)
+			}
+			assigned[user] = resp.Model
+		}
+	}
+
+	perArm := make(map[string]int)
+	for _, model := range assigned {
+		perArm[model] += 3
+	}
+
+	w := httptest.NewRecorder()
+	server.handleProxy(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader("#roxy list arms")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	for model, requests := range perArm {
+		expected := fmt.Sprintf("Rule: gpt-4, Model: %s, Requests: %d, Errors: 0 (0.0%%)", model, requests)
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("Expected arm metrics to contain %q, got %q", expected, w.Body.String())
+		}
+	}
+}
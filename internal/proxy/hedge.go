@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/CiaranMcAleer/roxy/internal/rotation"
+)
+
+const defaultHedgeDelay = 500 * time.Millisecond
+
+// hedgeResult is the outcome of one racing attempt.
+type hedgeResult struct {
+	attempt *attempt
+	err     error
+}
+
+// hedge sends req to the first of models and, if no response arrives
+// within delay, races it against a second attempt on another key or model.
+// The first usable response wins and the other attempt is cancelled; both
+// are settled with the rotator. A failed first attempt launches the hedge
+// at once rather than waiting out the delay.
+func (s *Server) hedge(r *http.Request, req *LLMRequest, models []string, estimate int, delay time.Duration) (*attempt, error) {
+	if delay <= 0 {
+		delay = defaultHedgeDelay
+	}
+
+	tried := make(map[string][]*rotation.ApiKey)
+	results := make(chan hedgeResult, 2)
+	cancels := make(map[*attempt]context.CancelFunc)
+
+	launch := func() bool {
+		a := s.nextAttempt(models, estimate, tried)
+		if a == nil {
+			return false
+		}
+		tried[a.model] = append(tried[a.model], a.key.ApiKey)
+
+		ctx, cancel := context.WithCancel(r.Context())
+		cancels[a] = cancel
+		go func() {
+			err := s.try(r.WithContext(ctx), req, a)
+			results <- hedgeResult{attempt: a, err: err}
+		}()
+		return true
+	}
+
+	if !launch() {
+		return nil, errNoKeys
+	}
+	pending, hedged := 1, false
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var last *attempt
+	var lastErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if !hedged {
+				hedged = true
+				if launch() {
+					pending++
+				}
+			}
+
+		case res := <-results:
+			pending--
+			a := res.attempt
+
+			if res.err != nil {
+				s.rotator.Settle(a.key, estimate, 0)
+				cancels[a]()
+				lastErr = res.err
+			} else if !s.retryable(req, a) {
+				// The winner keeps its context until its body is closed
+				a.resp.Body = cancelOnClose{a.resp.Body, cancels[a]}
+				delete(cancels, a)
+				if last != nil {
+					s.discard(last, estimate)
+				}
+				for _, cancel := range cancels {
+					cancel()
+				}
+				if pending > 0 {
+					go s.discardPending(results, pending, estimate)
+				}
+				return a, nil
+			} else {
+				// Keep the failure to relay if the other attempt fails too
+				if last != nil {
+					s.discard(last, estimate)
+					cancels[last]()
+				}
+				last = a
+			}
+
+			if !hedged {
+				hedged = true
+				if launch() {
+					pending++
+				}
+			}
+		}
+	}
+
+	for a, cancel := range cancels {
+		if a != last {
+			cancel()
+		}
+	}
+	if last != nil {
+		last.resp.Body = cancelOnClose{last.resp.Body, cancels[last]}
+		return last, nil
+	}
+	return nil, lastErr
+}
+
+// discard settles an attempt whose response will not be used.
+func (s *Server) discard(a *attempt, estimate int) {
+	a.resp.Body.Close()
+	s.rotator.Settle(a.key, estimate, 0)
+}
+
+// discardPending waits for the attempts that lost the race, which have
+// already been cancelled, and settles them.
+func (s *Server) discardPending(results <-chan hedgeResult, pending, estimate int) {
+	for ; pending > 0; pending-- {
+		res := <-results
+		if res.err != nil {
+			s.rotator.Settle(res.attempt.key, estimate, 0)
+			continue
+		}
+		s.discard(res.attempt, estimate)
+	}
+}
+
+// cancelOnClose releases an attempt's context along with its body.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CiaranMcAleer/roxy/internal/config"
+	"github.com/CiaranMcAleer/roxy/internal/testutils"
+)
+
+func TestHedgedRequests(t *testing.T) {
+	keys := []config.APIKeyConfig{
+		{Key: "test-openai-key-1", Provider: "openai", MaxRPM: 1, MaxTPM: 40000},
+		{Key: "test-openai-key-2", Provider: "openai", MaxRPM: 1, MaxTPM: 40000},
+	}
+	rules := []config.ModelRule{
+		{SourceModel: "gpt-4", TargetModels: []string{"gpt-4o"}, SelectionPolicy: "hedged", HedgeDelayMs: 50},
+	}
+
+	t.Run("slow first attempt is raced and cancelled", func(t *testing.T) {
+		mockUpstream, cancelled, requests := testutils.MockSlowKeyServer("test-openai-key-1", 10*time.Second)
+		defer mockUpstream.Close()
+
+		server := newRetryTestServer(t, mockUpstream.URL, "", keys, rules)
+
+		start := time.Now()
+		w := sendChat(server, "gpt-4")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Expected the hedge to answer quickly, took %v", elapsed)
+		}
+
+		select {
+		case <-cancelled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Losing attempt was not cancelled")
+		}
+		if *requests != 2 {
+			t.Errorf("Expected 2 upstream requests, got %d", *requests)
+		}
+
+		// Both attempts count against their key's max_rpm of 1
+		body := `{"model": "gpt-4", "messages": [{"role": "user", "content": "Hello again"}]}`
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		w = httptest.NewRecorder()
+		server.handleProxy(w, req)
+		if w.Code != http.StatusTooManyRequests {
+			t.Errorf("Expected status 429 with both keys used, got %d", w.Code)
+		}
+	})
+
+	t.Run("fast first attempt is not hedged", func(t *testing.T) {
+		mockUpstream, _, requests := testutils.MockSlowKeyServer("test-openai-key-2", 10*time.Second)
+		defer mockUpstream.Close()
+
+		server := newRetryTestServer(t, mockUpstream.URL, "", keys, rules)
+
+		if w := sendChat(server, "gpt-4"); w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		time.Sleep(100 * time.Millisecond)
+		if *requests != 1 {
+			t.Errorf("Expected 1 upstream request, got %d", *requests)
+		}
+	})
+}
@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"encoding/json"
+
+	"github.com/CiaranMcAleer/roxy/internal/provider"
+)
+
+// apiFormat is the API shape a client wrote its request in. Requests are
+// always routed in the OpenAI shape; responses are converted back into the
+// client's format.
+type apiFormat int
+
+const (
+	formatOpenAI apiFormat = iota
+	formatAnthropic
+)
+
+func (f apiFormat) String() string {
+	if f == formatAnthropic {
+		return "anthropic"
+	}
+	return "openai"
+}
+
+// LLMRequest holds the fields of an inbound request that Roxy routes and
+// caches on. The original document is kept alongside so that everything
+// Roxy does not interpret (tools, response_format, image parts, ...) is
+// forwarded to the provider untouched.
+type LLMRequest struct {
+	Model               string          `json:"model"`
+	Messages            []ChatMessage   `json:"messages,omitempty"`
+	Prompt              json.RawMessage `json:"prompt,omitempty"`
+	MaxTokens           int             `json:"max_tokens,omitempty"`
+	MaxCompletionTokens int             `json:"max_completion_tokens,omitempty"`
+	Temperature         float64         `json:"temperature,omitempty"`
+	Stream              bool            `json:"stream,omitempty"`
+	User                string          `json:"user,omitempty"`
+
+	body []byte
+
+	format apiFormat
+	// native is the original body for requests that did not arrive in the
+	// OpenAI shape. It is forwarded as-is to a provider speaking that format.
+	native []byte
+}
+
+// ChatMessage is a single chat turn. Content is left as raw JSON because it
+// may be a plain string, an array of content parts, or null.
+type ChatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+func parseRequest(body []byte) (*LLMRequest, error) {
+	var req LLMRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	req.body = body
+	return &req, nil
+}
+
+// parseAnthropicRequest parses a Messages API request body, converting it
+// into the OpenAI shape for routing.
+func parseAnthropicRequest(body []byte) (*LLMRequest, error) {
+	chatBody, err := provider.AnthropicToChatRequest(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := parseRequest(chatBody)
+	if err != nil {
+		return nil, err
+	}
+	req.format = formatAnthropic
+	req.native = body
+	return req, nil
+}
+
+// Rough sizing used to reserve TPM capacity before a request is sent. The
+// reservation is replaced by the provider-reported usage afterwards.
+const (
+	bytesPerToken           = 4
+	defaultCompletionTokens = 512
+)
+
+// estimateTokens guesses the total tokens req will use from its prompt size
+// and completion limit.
+func (r *LLMRequest) estimateTokens() int {
+	return r.promptTokens() + r.completionTokens()
+}
+
+// promptTokens guesses the size of req's prompt in tokens.
+func (r *LLMRequest) promptTokens() int {
+	prompt := len(r.Prompt)
+	for _, msg := range r.Messages {
+		prompt += len(msg.Role) + len(msg.Content)
+	}
+	return prompt / bytesPerToken
+}
+
+// completionTokens returns req's completion limit, or a typical completion
+// size if it sets none.
+func (r *LLMRequest) completionTokens() int {
+	completion := r.MaxTokens
+	if r.MaxCompletionTokens > 0 {
+		completion = r.MaxCompletionTokens
+	}
+	if completion <= 0 {
+		completion = defaultCompletionTokens
+	}
+	return completion
+}
+
+// capabilities returns the model capabilities req relies on, as named in
+// the model catalogue.
+func (r *LLMRequest) capabilities() []string {
+	var doc struct {
+		Tools          []json.RawMessage `json:"tools"`
+		Functions      []json.RawMessage `json:"functions"`
+		ResponseFormat struct {
+			Type string `json:"type"`
+		} `json:"response_format"`
+	}
+	json.Unmarshal(r.body, &doc)
+
+	var capabilities []string
+	if len(doc.Tools) > 0 || len(doc.Functions) > 0 {
+		capabilities = append(capabilities, "tools")
+	}
+	if r.hasImages() {
+		capabilities = append(capabilities, "vision")
+	}
+	if doc.ResponseFormat.Type == "json_object" || doc.ResponseFormat.Type == "json_schema" {
+		capabilities = append(capabilities, "json_mode")
+	}
+	return capabilities
+}
+
+// deterministic reports whether req asks for repeatable output: a
+// temperature of 0 or a seed. A request that sets no temperature gets the
+// provider's default, which isn't 0.
+func (r *LLMRequest) deterministic() bool {
+	var doc struct {
+		Temperature *float64 `json:"temperature"`
+		Seed        *int64   `json:"seed"`
+	}
+	json.Unmarshal(r.body, &doc)
+	return doc.Seed != nil || (doc.Temperature != nil && *doc.Temperature == 0)
+}
+
+func (r *LLMRequest) hasImages() bool {
+	for _, msg := range r.Messages {
+		var parts []struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(msg.Content, &parts) != nil {
+			continue
+		}
+		for _, part := range parts {
+			if part.Type == "image_url" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unstreamed returns req as a request for the whole response at once.
+func (r *LLMRequest) unstreamed() (*LLMRequest, error) {
+	if !r.Stream {
+		return r, nil
+	}
+
+	c := *r
+	c.Stream = false
+	var err error
+	if c.body, err = provider.DropFields(r.body, "stream", "stream_options"); err != nil {
+		return nil, err
+	}
+	if r.native != nil {
+		if c.native, err = provider.DropFields(r.native, "stream"); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// nativeTo reports whether p speaks the client's own format, in which case
+// the request and response skip translation entirely.
+func (r *LLMRequest) nativeTo(p provider.Provider) bool {
+	return r.format == formatAnthropic && p.Name() == "anthropic"
+}
+
+// providerBody returns the body to send to p for model.
+func (r *LLMRequest) providerBody(p provider.Provider, model string) ([]byte, error) {
+	if r.nativeTo(p) {
+		return provider.SetModel(r.native, model)
+	}
+	return p.TranslateRequest(r.body, model)
+}
+
+// clientBody converts a successful response body from p into the client's
+// format.
+func (r *LLMRequest) clientBody(p provider.Provider, body []byte) ([]byte, error) {
+	if r.nativeTo(p) {
+		return body, nil
+	}
+
+	body, err := p.TranslateResponse(body)
+	if err != nil || r.format != formatAnthropic {
+		return body, err
+	}
+	return provider.ChatToAnthropicResponse(body)
+}
+
+// clientError converts an error response body from p into the client's
+// format.
+func (r *LLMRequest) clientError(p provider.Provider, status int, body []byte) []byte {
+	if r.format != formatAnthropic || r.nativeTo(p) {
+		return body
+	}
+	return provider.ChatToAnthropicError(status, body)
+}
+
+// clientStream returns the translator that turns p's stream events into
+// the client's format.
+func (r *LLMRequest) clientStream(p provider.Provider) provider.StreamTranslator {
+	translator := p.TranslateStream(r.body)
+	if r.nativeTo(p) {
+		return nativeStream{translator}
+	}
+	if r.format == formatAnthropic {
+		return chainedStream{translator, provider.NewAnthropicStreamEncoder()}
+	}
+	return translator
+}
@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CiaranMcAleer/roxy/internal/config"
+	"github.com/CiaranMcAleer/roxy/internal/provider"
+	"github.com/CiaranMcAleer/roxy/internal/rotation"
+)
+
+// Failures a retry policy can retry, as named in retry_on.
+const (
+	retryRateLimited     = "rate_limited"
+	retryOverloaded      = "overloaded"
+	retryServerError     = "server_error"
+	retryTimeout         = "timeout"
+	retryConnectionError = "connection_error"
+	retryAuth            = "auth"
+)
+
+var defaultRetryOn = []string{retryRateLimited, retryOverloaded, retryServerError, retryTimeout, retryConnectionError}
+
+// errNoKeys reports that no attempt could be made because every candidate
+// provider was out of keys.
+var errNoKeys = errors.New("no available API keys")
+
+type retryPolicy struct {
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	retryOn     map[string]bool
+}
+
+func newRetryPolicy(cfg config.RetryConfig) retryPolicy {
+	p := retryPolicy{
+		maxAttempts: cfg.MaxAttempts,
+		backoff:     time.Duration(cfg.BackoffMs) * time.Millisecond,
+		maxBackoff:  time.Duration(cfg.MaxBackoffMs) * time.Millisecond,
+		retryOn:     make(map[string]bool),
+	}
+	if p.maxAttempts <= 0 {
+		p.maxAttempts = 3
+	}
+	if p.backoff <= 0 {
+		p.backoff = 100 * time.Millisecond
+	}
+	if p.maxBackoff <= 0 {
+		p.maxBackoff = 2 * time.Second
+	}
+
+	retryOn := cfg.RetryOn
+	if len(retryOn) == 0 {
+		retryOn = defaultRetryOn
+	}
+	for _, cond := range retryOn {
+		p.retryOn[strings.ToLower(cond)] = true
+	}
+	return p
+}
+
+// delay returns how long to wait before the nth retry: exponential in n,
+// capped, with the upper half randomised so that clients that failed
+// together don't retry together.
+func (p retryPolicy) delay(n int) time.Duration {
+	d := p.backoff
+	for i := 1; i < n && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// attempt is one provider request made on behalf of a client request.
+type attempt struct {
+	provider provider.Provider
+	model    string
+	key      *rotation.Reservation
+	resp     *http.Response
+
+	// start and headers time the request for the adaptive policy
+	start   time.Time
+	headers time.Time
+}
+
+// try sends a, recording transport failures against its target.
+func (s *Server) try(r *http.Request, req *LLMRequest, a *attempt) error {
+	a.start = time.Now()
+	resp, err := s.forward(r, req, a.provider, a.model, a.key)
+	if err != nil {
+		if r.Context().Err() == nil {
+			s.recordFailure(req, a)
+		}
+		return err
+	}
+	a.resp, a.headers = resp, time.Now()
+	return nil
+}
+
+// retryable reports whether a's response is a failure the retry policy
+// covers, recording it against the target if so.
+func (s *Server) retryable(req *LLMRequest, a *attempt) bool {
+	if !s.retry.retryOn[responseFailure(s.classify(a.provider, a.key, a.resp))] {
+		return false
+	}
+	s.recordFailure(req, a)
+	return true
+}
+
+// send forwards req to its candidate models until one attempt succeeds
+// or fails in a way the retry policy doesn't cover. Every other key of a
+// provider is tried before moving on to the next model, and once all are
+// exhausted the candidates are tried again from the start while attempts
+// remain. The returned attempt's key still holds its reservation; when
+// every attempt failed it is the last failure, to relay to the client.
+func (s *Server) send(r *http.Request, req *LLMRequest, models []string, estimate int) (*attempt, error) {
+	tried := make(map[string][]*rotation.ApiKey)
+
+	var last *attempt
+	lastErr := errNoKeys
+	for n := 0; n < s.retry.maxAttempts; n++ {
+		next := s.nextAttempt(models, estimate, tried)
+		if next == nil && len(tried) > 0 {
+			// Every key has been tried once; start over with all of them
+			tried = make(map[string][]*rotation.ApiKey)
+			next = s.nextAttempt(models, estimate, tried)
+		}
+		if next == nil {
+			break
+		}
+		tried[next.model] = append(tried[next.model], next.key.ApiKey)
+
+		if n > 0 {
+			if err := sleep(r.Context(), s.retry.delay(n)); err != nil {
+				s.rotator.Release(next.key, estimate)
+				break
+			}
+		}
+		if last != nil {
+			// The new attempt replaces the failure we were holding on to
+			s.rotator.Settle(last.key, estimate, 0)
+			last.resp.Body.Close()
+			last = nil
+		}
+
+		if err := s.try(r, req, next); err != nil {
+			s.rotator.Settle(next.key, estimate, 0)
+			lastErr = err
+			if r.Context().Err() != nil || !s.retry.retryOn[transportFailure(err)] {
+				break
+			}
+			continue
+		}
+
+		if !s.retryable(req, next) {
+			return next, nil
+		}
+		last = next
+	}
+
+	if last != nil {
+		return last, nil
+	}
+	return nil, lastErr
+}
+
+// nextAttempt reserves a key for the first candidate model that has a key
+// it hasn't been tried with yet. tried holds the keys each model has been
+// tried with, so a model sharing its provider with an earlier one still
+// gets every key.
+func (s *Server) nextAttempt(models []string, estimate int, tried map[string][]*rotation.ApiKey) *attempt {
+	for _, model := range models {
+		p, ok := s.providers.Get(s.providerOf(model))
+		if !ok {
+			continue
+		}
+		key, err := s.rotator.Reserve(p.Name(), estimate, tried[model]...)
+		if err != nil {
+			continue
+		}
+		return &attempt{provider: p, model: model, key: key}
+	}
+	return nil
+}
+
+// candidateModels returns the models req may be sent to: the one chosen by
+// the rule's selection policy, followed by the rule's other targets in
+// order. Under the cheapest policy they are the targets able to serve req,
+// cheapest first.
+func (s *Server) candidateModels(r *http.Request, req *LLMRequest) []string {
+	rule := s.ruleFor(req.Model)
+	if rule != nil && rule.SelectionPolicy == "cheapest" {
+		return s.cheapest(rule.TargetModels, req)
+	}
+
+	var first string
+	if rule != nil && rule.SelectionPolicy == "weighted" {
+		first = weightedTarget(rule, stickyID(rule, r, req))
+	} else {
+		first, _ = s.getTargetModel(req.Model)
+	}
+	models := []string{first}
+
+	if rule != nil {
+		for _, model := range rule.TargetModels {
+			if model != first {
+				models = append(models, model)
+			}
+		}
+	}
+	return models
+}
+
+// responseFailure names the retry_on condition an error response meets.
+func responseFailure(class provider.ErrorClass) string {
+	switch class {
+	case provider.ErrRateLimited:
+		return retryRateLimited
+	case provider.ErrOverloaded:
+		return retryOverloaded
+	case provider.ErrServer:
+		return retryServerError
+	case provider.ErrAuth:
+		return retryAuth
+	}
+	return ""
+}
+
+// transportFailure names the retry_on condition a failed request meets.
+func transportFailure(err error) string {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return retryTimeout
+	}
+	return retryConnectionError
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CiaranMcAleer/roxy/internal/config"
+	"github.com/CiaranMcAleer/roxy/internal/testutils"
+)
+
+func newRetryTestServer(t *testing.T, openAIURL, anthropicURL string, keys []config.APIKeyConfig, rules []config.ModelRule) *Server {
+	t.Helper()
+
+	cfg := &config.Config{
+		ListenAddr: ":8080",
+		APIKeys:    keys,
+		ModelRules: rules,
+		Retry:      config.RetryConfig{BackoffMs: 1, MaxBackoffMs: 5},
+	}
+	cfg.Providers.OpenAI.BaseURL = openAIURL
+	cfg.Providers.Anthropic.BaseURL = anthropicURL
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	return server
+}
+
+func sendChat(server *Server, model string) *httptest.ResponseRecorder {
+	body := `{"model": "` + model + `", "messages": [{"role": "user", "content": "Hello"}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	server.handleProxy(w, req)
+	return w
+}
+
+func TestRetryEngine(t *testing.T) {
+	twoKeys := []config.APIKeyConfig{
+		{Key: "test-openai-key-1", Provider: "openai", MaxRPM: 60, MaxTPM: 40000},
+		{Key: "test-openai-key-2", Provider: "openai", MaxRPM: 60, MaxTPM: 40000},
+	}
+	oneKey := twoKeys[:1]
+
+	testCases := []struct {
+		name           string
+		failures       int
+		status         int
+		keys           []config.APIKeyConfig
+		expectedStatus int
+		expectedKeys   []string
+	}{
+		{
+			name:           "server error retried on another key",
+			failures:       1,
+			status:         http.StatusInternalServerError,
+			keys:           twoKeys,
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"test-openai-key-1", "test-openai-key-2"},
+		},
+		{
+			name:           "server error retried on the same key when it is the only one",
+			failures:       1,
+			status:         http.StatusBadGateway,
+			keys:           oneKey,
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"test-openai-key-1", "test-openai-key-1"},
+		},
+		{
+			name:           "client error is not retried",
+			failures:       1,
+			status:         http.StatusBadRequest,
+			keys:           twoKeys,
+			expectedStatus: http.StatusBadRequest,
+			expectedKeys:   []string{"test-openai-key-1"},
+		},
+		{
+			name:           "attempts are capped and the last failure relayed",
+			failures:       10,
+			status:         http.StatusInternalServerError,
+			keys:           oneKey,
+			expectedStatus: http.StatusInternalServerError,
+			expectedKeys:   []string{"test-openai-key-1", "test-openai-key-1", "test-openai-key-1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUpstream, seen := testutils.MockFlakyServer(tc.failures, tc.status)
+			defer mockUpstream.Close()
+
+			server := newRetryTestServer(t, mockUpstream.URL, "", tc.keys, nil)
+			server.rotator.SetStrategy("openai", "lru")
+
+			w := sendChat(server, "gpt-4")
+			if w.Code != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tc.expectedStatus, w.Code, w.Body.String())
+			}
+			if strings.Join(*seen, ",") != strings.Join(tc.expectedKeys, ",") {
+				t.Errorf("Expected keys %v, got %v", tc.expectedKeys, *seen)
+			}
+		})
+	}
+}
+
+func TestRetryFailsOverBetweenModelsOfOneProvider(t *testing.T) {
+	mockOpenAI, models := testutils.MockModelFailureServer("gpt-4", http.StatusInternalServerError)
+	defer mockOpenAI.Close()
+
+	keys := []config.APIKeyConfig{
+		{Key: "test-openai-key", Provider: "openai", MaxRPM: 60, MaxTPM: 40000},
+	}
+
+	for _, policy := range []string{"fallback", "hedged"} {
+		t.Run(policy, func(t *testing.T) {
+			*models = nil
+			rules := []config.ModelRule{
+				{SourceModel: "gpt-4", TargetModels: []string{"gpt-4", "gpt-4o"}, SelectionPolicy: policy},
+			}
+			server := newRetryTestServer(t, mockOpenAI.URL, "", keys, rules)
+
+			w := sendChat(server, "gpt-4")
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "gpt-4o") {
+				t.Errorf("Expected the response of gpt-4o, got %d: %s", w.Code, w.Body.String())
+			}
+			if strings.Join(*models, ",") != "gpt-4,gpt-4o" {
+				t.Errorf("Expected gpt-4 then gpt-4o to be tried, got %v", *models)
+			}
+		})
+	}
+}
+
+func TestRetryFailsOverToOtherModels(t *testing.T) {
+	mockOpenAI, seen := testutils.MockFlakyServer(10, http.StatusServiceUnavailable)
+	defer mockOpenAI.Close()
+
+	mockAnthropic := testutils.MockAnthropicServer()
+	defer mockAnthropic.Close()
+
+	keys := []config.APIKeyConfig{
+		{Key: "test-openai-key", Provider: "openai", MaxRPM: 60, MaxTPM: 40000},
+		{Key: "test-anthropic-key", Provider: "anthropic", MaxRPM: 60, MaxTPM: 40000},
+	}
+
+	for _, policy := range []string{"random", "roundrobin", "fallback"} {
+		t.Run(policy, func(t *testing.T) {
+			rules := []config.ModelRule{
+				{SourceModel: "gpt-4", TargetModels: []string{"gpt-4o", "claude-3-haiku"}, SelectionPolicy: policy},
+			}
+			server := newRetryTestServer(t, mockOpenAI.URL, mockAnthropic.URL, keys, rules)
+
+			// Whichever model the policy picks first, the request ends up on
+			// the working provider
+			w := sendChat(server, "gpt-4")
+			if w.Code != http.StatusOK {
+				t.Errorf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if len(*seen) == 0 {
+		t.Error("Expected at least one policy to try the failing provider first")
+	}
+}
+
+func TestFallbackKeepsKeyRotation(t *testing.T) {
+	mockOpenAI, seen := testutils.MockFlakyServer(0, http.StatusInternalServerError)
+	defer mockOpenAI.Close()
+
+	keys := []config.APIKeyConfig{
+		{Key: "test-openai-key-1", Provider: "openai", MaxRPM: 60, MaxTPM: 40000},
+		{Key: "test-openai-key-2", Provider: "openai", MaxRPM: 60, MaxTPM: 40000},
+	}
+	rules := []config.ModelRule{
+		{SourceModel: "gpt-4", TargetModels: []string{"gpt-4o"}, SelectionPolicy: "fallback"},
+	}
+	server := newRetryTestServer(t, mockOpenAI.URL, "", keys, rules)
+	server.rotator.SetStrategy("openai", "round_robin")
+	defer server.cache.Close()
+
+	for i := 0; i < 4; i++ {
+		body := fmt.Sprintf(`{"model": "gpt-4", "messages": [{"role": "user", "content": "Hello %d"}]}`, i)
+		w := httptest.NewRecorder()
+		server.handleProxy(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	expected := "test-openai-key-1,test-openai-key-2,test-openai-key-1,test-openai-key-2"
+	if strings.Join(*seen, ",") != expected {
+		t.Errorf("Expected keys %s, got %v", expected, *seen)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := newRetryPolicy(config.RetryConfig{BackoffMs: 100, MaxBackoffMs: 300})
+
+	tests := []struct {
+		retry    int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 150 * time.Millisecond, 300 * time.Millisecond},
+		{10, 150 * time.Millisecond, 300 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if d := policy.delay(tt.retry); d < tt.min || d > tt.max {
+				t.Errorf("Retry %d: expected delay in [%v, %v], got %v", tt.retry, tt.min, tt.max, d)
+			}
+		}
+	}
+}
@@ -3,17 +3,18 @@ package proxy
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/json"
 	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/CiaranMcAleer/roxy/internal/cache"
 	"github.com/CiaranMcAleer/roxy/internal/config"
+	"github.com/CiaranMcAleer/roxy/internal/provider"
 	"github.com/CiaranMcAleer/roxy/internal/rotation"
 )
 
@@ -21,16 +22,32 @@ type Server struct {
 	cfg        *config.Config
 	httpServer *http.Server
 	rotator    *rotation.KeyRotator
+	providers  *provider.Registry
 	mu         sync.RWMutex
 	// Add round-robin counters
-	modelCounters map[string]int
+	modelCounters  map[string]int
 	commandHandler *CommandHandler
-	cache      *cache.Cache
+	cache          cache.Store
+	retry          retryPolicy
+	client         *http.Client
+	health         *healthTracker
+	catalogue      map[string]config.ModelInfo
+	arms           *armMetrics
+	shadow         *shadower
+
+	// Upstream requests under way that identical requests can share, and
+	// the goroutines making them, which outlive the requests that started
+	// them
+	flightsMu sync.Mutex
+	flights   map[string]*flight
+	flightsWG sync.WaitGroup
 }
 
 type CommandHandler struct {
 	cfg     *config.Config
 	rotator *rotation.KeyRotator
+	arms    *armMetrics
+	cache   cache.Store
 	mu      sync.RWMutex
 }
 
@@ -41,36 +58,65 @@ func NewCommandHandler(cfg *config.Config, rotator *rotation.KeyRotator) *Comman
 	}
 }
 
-type LLMRequest struct {
-	Model       string        `json:"model"`
-	Messages    []ChatMessage `json:"messages,omitempty"`
-	Prompt      string        `json:"prompt,omitempty"`
-	MaxTokens   int           `json:"max_tokens,omitempty"`
-	Temperature float64       `json:"temperature,omitempty"`
-}
-
-type ChatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
 func NewServer(cfg *config.Config) (*Server, error) {
 	rotator := rotation.NewKeyRotator(cfg.APIKeys)
+	for _, name := range config.ProviderNames() {
+		rotator.SetStrategy(name, rotation.Strategy(cfg.Providers.Endpoint(name).KeyStrategy))
+	}
+	rotator.SetBreakerSettings(rotation.BreakerSettings{
+		KeyFailures:      cfg.CircuitBreaker.KeyFailures,
+		ProviderFailures: cfg.CircuitBreaker.ProviderFailures,
+		OpenFor:          time.Duration(cfg.CircuitBreaker.OpenSec) * time.Second,
+		Probes:           cfg.CircuitBreaker.HalfOpenProbes,
+	})
+
+	arms := newArmMetrics()
+	responses, err := cache.Open(cache.Options{
+		Backend:    cfg.Cache.Backend,
+		TTL:        time.Duration(cfg.Cache.TTLSec) * time.Second,
+		MaxEntries: cfg.Cache.MaxEntries,
+		MaxBytes:   cfg.Cache.MaxBytes,
+		Eviction:   cfg.Cache.Eviction,
+		Sweep:      time.Duration(cfg.Cache.SweepSec) * time.Second,
+		Path:       cfg.Cache.Path,
+		Redis: cache.RedisOptions{
+			Addr:      cfg.Cache.Redis.Addr,
+			Password:  cfg.Cache.Redis.Password,
+			DB:        cfg.Cache.Redis.DB,
+			KeyPrefix: cfg.Cache.Redis.KeyPrefix,
+			Timeout:   time.Duration(cfg.Cache.Redis.TimeoutMs) * time.Millisecond,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("opening response cache: %w", err)
+	}
+	commandHandler := NewCommandHandler(cfg, rotator)
+	commandHandler.arms = arms
+	commandHandler.cache = responses
 
 	server := &Server{
-		cfg:           cfg,
-		rotator:       rotator,
-		modelCounters: make(map[string]int),
-		commandHandler: NewCommandHandler(cfg, rotator),
-		cache:        cache.New(5 * time.Minute), // 5 minute TTL
+		cfg:            cfg,
+		rotator:        rotator,
+		providers:      provider.NewRegistry(cfg),
+		modelCounters:  make(map[string]int),
+		commandHandler: commandHandler,
+		cache:          responses,
+		retry:          newRetryPolicy(cfg.Retry),
+		client:         &http.Client{Transport: newTransport()},
+		health:         newHealthTracker(),
+		catalogue:      cfg.Catalogue(),
+		arms:           arms,
+		shadow:         newShadower(),
+		flights:        make(map[string]*flight),
 	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/v1/", server.handleProxy)
+	mux.HandleFunc("/v1/messages", server.handleMessages)
 
 	server.httpServer = &http.Server{
 		Addr:    cfg.ListenAddr,
@@ -85,7 +131,19 @@ func (s *Server) Start() error {
 }
 
 func (s *Server) Shutdown() error {
-	return s.httpServer.Shutdown(context.Background())
+	err := s.httpServer.Shutdown(context.Background())
+	// Let shared requests, which may still mirror and cache their
+	// responses, and then mirrored requests finish before dropping
+	// connections
+	s.flightsWG.Wait()
+	if shadowErr := s.shadow.close(); err == nil {
+		err = shadowErr
+	}
+	if cacheErr := s.cache.Close(); err == nil {
+		err = cacheErr
+	}
+	s.client.CloseIdleConnections()
+	return err
 }
 
 func (s *Server) getNextModelIndex(model string, total int) int {
@@ -99,43 +157,47 @@ func (s *Server) getNextModelIndex(model string, total int) int {
 }
 
 func (s *Server) getTargetModel(sourceModel string) (string, string) {
-	for _, rule := range s.cfg.ModelRules {
-		if rule.SourceModel == sourceModel {
-			switch rule.SelectionPolicy {
-			case "random":
-				model := rule.TargetModels[rand.Intn(len(rule.TargetModels))]
-				return model, getProviderForModel(model)
-			case "roundrobin":
-				idx := s.getNextModelIndex(sourceModel, len(rule.TargetModels))
-				model := rule.TargetModels[idx]
-				return model, getProviderForModel(model)
-			case "fallback":
-				for _, model := range rule.TargetModels {
-					provider := getProviderForModel(model)
-					if key, err := s.rotator.GetKey(provider); err == nil {
-						s.rotator.ReportUsage(key, 0) // Return key to pool
-						return model, provider
-					}
+	if rule := s.ruleFor(sourceModel); rule != nil {
+		switch rule.SelectionPolicy {
+		case "random":
+			model := rule.TargetModels[rand.Intn(len(rule.TargetModels))]
+			return model, s.providerOf(model)
+		case "roundrobin":
+			idx := s.getNextModelIndex(sourceModel, len(rule.TargetModels))
+			model := rule.TargetModels[idx]
+			return model, s.providerOf(model)
+		case "adaptive":
+			model := s.health.pick(rule.TargetModels)
+			return model, s.providerOf(model)
+		case "weighted":
+			model := weightedTarget(rule, "")
+			return model, s.providerOf(model)
+		case "hedged":
+			return rule.TargetModels[0], s.providerOf(rule.TargetModels[0])
+		case "fallback":
+			for _, model := range rule.TargetModels {
+				provider := s.providerOf(model)
+				if s.rotator.HasKey(provider) {
+					return model, provider
 				}
 			}
 		}
 	}
-	return sourceModel, getProviderForModel(sourceModel)
+	return sourceModel, s.providerOf(sourceModel)
 }
 
-func getProviderForModel(model string) string {
-	switch {
-	case strings.HasPrefix(model, "gpt-"):
-		return "openai"
-	case strings.HasPrefix(model, "claude-"):
-		return "anthropic"
-	case strings.HasPrefix(model, "openrouter/"):
-		return "openrouter"
-	case strings.HasPrefix(model, "chutes/"):
-		return "chutes"
-	default:
-		return "openai" // Default to OpenAI
-	}
+// ruleFor returns the model rule for sourceModel, or nil if there is none.
+// Pattern rules come back with their capture groups substituted into the
+// targets.
+func (s *Server) ruleFor(sourceModel string) *config.ModelRule {
+	return s.cfg.MatchRule(sourceModel)
+}
+
+// providerOf returns the name of the provider that serves model, or "" if
+// no provider is configured for it.
+func (s *Server) providerOf(model string) string {
+	name, _, _ := s.cfg.ProviderFor(model)
+	return name
 }
 
 func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
@@ -154,157 +216,268 @@ func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse the request
-	var req LLMRequest
-	if err := json.Unmarshal(body, &req); err != nil {
+	req, err := parseRequest(body)
+	if err != nil {
 		http.Error(w, "Invalid request format", http.StatusBadRequest)
 		return
 	}
 
-	// Check cache
-	cacheKey := generateCacheKey(&req)
-	if cached, exists := s.cache.Get(cacheKey); exists {
-		w.Write(cached)
-		return
-	}
-
-	// Get target model and provider
-	targetModel, provider := s.getTargetModel(req.Model)
+	s.serve(w, r, req)
+}
 
-	// Get API key
-	key, err := s.rotator.GetKey(provider)
+// handleMessages serves clients written against the Anthropic Messages API.
+// Requests go through the same routing, key rotation and cache as OpenAI
+// requests and are answered in the Messages API format.
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "No available API keys", http.StatusTooManyRequests)
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
 		return
 	}
-	defer s.rotator.ReportUsage(key, 0) // Will be updated with actual token count
+	r.Body.Close()
 
-	// Modify request for target model
-	req.Model = targetModel
-	modifiedBody, err := json.Marshal(req)
+	req, err := parseAnthropicRequest(body)
 	if err != nil {
-		http.Error(w, "Failed to prepare request", http.StatusInternalServerError)
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
 		return
 	}
 
-	// Prepare the provider request
-	var targetURL string
-	switch provider {
-	case "openai":
-		targetURL = fmt.Sprintf("%s/chat/completions", s.cfg.Providers.OpenAI.BaseURL)
-	case "anthropic":
-		targetURL = fmt.Sprintf("%s/complete", s.cfg.Providers.Anthropic.BaseURL)
-	default:
-		http.Error(w, "Unsupported provider", http.StatusBadRequest)
+	s.serve(w, r, req)
+}
+
+// serve routes req to a provider and writes the response in the client's
+// format.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, req *LLMRequest) {
+	rule := s.ruleFor(req.Model)
+	if rule == nil && s.providerOf(req.Model) == "" {
+		http.Error(w, fmt.Sprintf("No provider configured for model: %s", req.Model), http.StatusBadRequest)
 		return
 	}
+	if rule != nil {
+		// Targets built from the source model can't be checked until now
+		for _, model := range rule.TargetModels {
+			if s.providerOf(model) == "" {
+				http.Error(w, fmt.Sprintf("No provider configured for model: %s", model), http.StatusBadRequest)
+				return
+			}
+		}
+	}
 
-	// Create provider request
-	proxyReq, err := http.NewRequest(r.Method, targetURL, bytes.NewBuffer(modifiedBody))
+	policy, err := s.cachePolicyFor(r, req)
 	if err != nil {
-		http.Error(w, "Failed to create provider request", http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Invalid cache header: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	// Copy headers and set authentication
-	copyHeaders(proxyReq.Header, r.Header)
-	switch provider {
-	case "openai":
-		proxyReq.Header.Set("Authorization", "Bearer "+key.Config.Key)
-	case "anthropic":
-		proxyReq.Header.Set("X-Api-Key", key.Config.Key)
+	// Check cache. A cache keyed on the target model needs the target
+	// chosen first.
+	var models []string
+	lookup := req.Model
+	if s.cfg.Cache.KeyModel == "target" {
+		models = s.candidateModels(r, req)
+		if len(models) > 0 {
+			lookup = models[0]
+		}
+	}
+	if policy.read {
+		if cached, storedAt, exists := s.cache.Get(s.cacheKey(r, req, lookup)); exists && policy.fresh(storedAt) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Roxy-Cache", "HIT")
+			w.Header().Set("Age", strconv.Itoa(int(time.Since(storedAt).Seconds())))
+			w.Write(cached)
+			return
+		}
+		w.Header().Set("X-Roxy-Cache", "MISS")
+	} else {
+		w.Header().Set("X-Roxy-Cache", "BYPASS")
+	}
+
+	if models == nil {
+		models = s.candidateModels(r, req)
+	}
+	if len(models) == 0 {
+		http.Error(w, "No target model supports this request", http.StatusBadRequest)
+		return
 	}
 
-	// Make the request
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(proxyReq)
+	// Concurrent identical requests share one upstream request. Streams
+	// only share with streams, as the responses differ, and requests only
+	// with those whose response is stored the same way.
+	if policy.share {
+		key := s.cacheKey(r, req, lookup)
+		if req.Stream {
+			key += ":stream"
+		}
+		key += fmt.Sprintf(":%t:%s", policy.write, policy.ttl)
+		s.coalesce(w, r, key, func(w http.ResponseWriter, r *http.Request) {
+			s.fetch(w, r, req, models, policy)
+		})
+		return
+	}
+	s.fetch(w, r, req, models, policy)
+}
+
+// fetch sends req to its candidate models and writes the response,
+// storing it in the cache if policy allows.
+func (s *Server) fetch(w http.ResponseWriter, r *http.Request, req *LLMRequest, models []string, policy cachePolicy) {
+	// Send to the target models, retrying failures on other keys and
+	// models. The estimated tokens are held against each key's TPM budget
+	// until the actual usage is known.
+	estimate := req.estimateTokens()
+	var a *attempt
+	var err error
+	if rule := s.ruleFor(req.Model); rule != nil && rule.SelectionPolicy == "hedged" {
+		a, err = s.hedge(r, req, models, estimate, time.Duration(rule.HedgeDelayMs)*time.Millisecond)
+	} else {
+		a, err = s.send(r, req, models, estimate)
+	}
+	if err == errNoKeys {
+		http.Error(w, "No available API keys", http.StatusTooManyRequests)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Provider request failed", http.StatusBadGateway)
 		return
 	}
+	p, key, resp := a.provider, a.key, a.resp
 	defer resp.Body.Close()
 
-	// If we get a rate limit error and we're using the first model,
-	// try the next model in the fallback chain
-	if resp.StatusCode == http.StatusTooManyRequests {
-		for _, rule := range s.cfg.ModelRules {
-			if rule.SourceModel == req.Model && rule.SelectionPolicy == "fallback" {
-				// Try the next model in the chain
-				for i := 1; i < len(rule.TargetModels); i++ {
-					nextModel := rule.TargetModels[i]
-					nextProvider := getProviderForModel(nextModel)
-					nextKey, err := s.rotator.GetKey(nextProvider)
-					if err != nil {
-						continue
-					}
-
-					req.Model = nextModel
-					modifiedBody, err = json.Marshal(req)
-					if err != nil {
-						http.Error(w, "Failed to prepare request", http.StatusInternalServerError)
-						return
-					}
-
-					var targetURL string
-					switch nextProvider {
-					case "openai":
-						targetURL = fmt.Sprintf("%s/chat/completions", s.cfg.Providers.OpenAI.BaseURL)
-					case "anthropic":
-						targetURL = fmt.Sprintf("%s/complete", s.cfg.Providers.Anthropic.BaseURL)
-					}
-
-					proxyReq, err = http.NewRequest(r.Method, targetURL, bytes.NewBuffer(modifiedBody))
-					if err != nil {
-						continue
-					}
-
-					copyHeaders(proxyReq.Header, r.Header)
-					switch nextProvider {
-					case "openai":
-						proxyReq.Header.Set("Authorization", "Bearer "+nextKey.Config.Key)
-					case "anthropic":
-						proxyReq.Header.Set("X-Api-Key", nextKey.Config.Key)
-					}
-
-					resp, err = client.Do(proxyReq)
-					if err == nil && resp.StatusCode == http.StatusOK {
-						break
-					}
-					if resp != nil {
-						resp.Body.Close()
-					}
-				}
-				break
-			}
+	if req.Stream && resp.StatusCode == http.StatusOK && isEventStream(resp) {
+		translator := &timedStream{StreamTranslator: req.clientStream(p)}
+		err := streamResponse(w, resp, translator)
+		s.rotator.Settle(key, estimate, usedTokens(translator.Usage(), estimate))
+		if err == nil && !translator.first.IsZero() {
+			s.recordSuccess(req, a, translator.first.Sub(a.start), translator.Usage())
+			s.mirror(r, req, a, nil, translator.Usage())
+		} else if err != nil && r.Context().Err() == nil {
+			s.recordFailure(req, a)
 		}
+		return
 	}
 
 	// Cache and return the response
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
+		s.rotator.Settle(key, estimate, 0)
 		http.Error(w, "Failed to read response", http.StatusInternalServerError)
 		return
 	}
 
 	if resp.StatusCode == http.StatusOK {
-		s.cache.Set(cacheKey, respBody)
+		usage := p.ParseUsage(respBody)
+		s.rotator.Settle(key, estimate, usedTokens(usage, estimate))
+		s.recordSuccess(req, a, a.headers.Sub(a.start), usage)
+		s.mirror(r, req, a, respBody, usage)
+		respBody, err = req.clientBody(p, respBody)
+		if err != nil {
+			http.Error(w, "Failed to translate provider response", http.StatusBadGateway)
+			return
+		}
+		if policy.write {
+			s.cache.Set(s.cacheKey(r, req, a.model), respBody, policy.ttl)
+		}
+	} else {
+		s.rotator.Settle(key, estimate, 0)
+		respBody = req.clientError(p, resp.StatusCode, respBody)
 	}
 
 	// Copy the final response
 	copyHeaders(w.Header(), resp.Header)
+	w.Header().Del("Content-Length")
 	w.WriteHeader(resp.StatusCode)
 	w.Write(respBody)
 }
 
-func generateCacheKey(req *LLMRequest) string {
-	hash := sha256.New()
-	hash.Write([]byte(req.Model))
-	for _, msg := range req.Messages {
-		hash.Write([]byte(msg.Role))
-		hash.Write([]byte(msg.Content))
+// usedTokens returns the tokens to account for a successful request,
+// falling back to the reservation estimate when the provider reported none.
+func usedTokens(usage provider.Usage, estimate int) int {
+	if total := usage.Total(); total > 0 {
+		return total
+	}
+	return estimate
+}
+
+// forward sends req to model on provider p, authenticated with key.
+func (s *Server) forward(r *http.Request, req *LLMRequest, p provider.Provider, model string, key *rotation.Reservation) (*http.Response, error) {
+	proxyReq, err := s.providerRequest(r, req, p, model, key)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.roundTrip(proxyReq, s.timeoutsFor(p.Name(), req.Model))
+	if err != nil {
+		// A client that went away says nothing about the provider
+		if r.Context().Err() == nil {
+			s.rotator.ReportFailure(key)
+		}
+		return nil, err
+	}
+
+	// Let the provider's own account of the key's quota steer selection
+	s.rotator.ReportHeaders(key.ApiKey, resp.Header)
+	return resp, nil
+}
+
+// providerRequest builds the request that sends req to model on provider
+// p, authenticated with key.
+func (s *Server) providerRequest(r *http.Request, req *LLMRequest, p provider.Provider, model string, key *rotation.Reservation) (*http.Request, error) {
+	_, name, _ := s.cfg.ProviderFor(model)
+	body, err := req.providerBody(p, name)
+	if err != nil {
+		return nil, fmt.Errorf("translating request for %s: %w", p.Name(), err)
+	}
+
+	// Bind the provider request to the client's context so that an
+	// abandoned request also cancels the upstream call
+	proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, p.URL(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating provider request: %w", err)
+	}
+
+	// Copy headers and set authentication
+	copyHeaders(proxyReq.Header, r.Header)
+	proxyReq.Header.Del("Accept-Encoding")
+	proxyReq.Header.Del("Authorization")
+	proxyReq.Header.Del("X-Api-Key")
+	p.Authenticate(proxyReq, key.Config.Key)
+	return proxyReq, nil
+}
+
+// classify returns the error class of resp and reports the outcome for key:
+// rate limited keys are benched, rejected keys disabled and server errors
+// counted towards the key's circuit breaker. An overloaded provider counts
+// as a server error, and only benches the key when it is Anthropic's 529 or
+// says when to retry; a plain 503 is left to the circuit breaker. The error
+// body is buffered so it can still be relayed to the client afterwards.
+func (s *Server) classify(p provider.Provider, key *rotation.Reservation, resp *http.Response) provider.ErrorClass {
+	if resp.StatusCode < 400 {
+		s.rotator.ReportSuccess(key)
+		return provider.ErrNone
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	class := p.ClassifyError(resp.StatusCode, body)
+	switch class {
+	case provider.ErrRateLimited:
+		s.rotator.Cooldown(key.ApiKey, rotation.RetryAfter(resp.Header, time.Now()))
+	case provider.ErrOverloaded:
+		retryAfter := rotation.RetryAfter(resp.Header, time.Now())
+		if resp.StatusCode == provider.StatusOverloaded || retryAfter > 0 {
+			s.rotator.Cooldown(key.ApiKey, retryAfter)
+		}
+		s.rotator.ReportFailure(key)
+	case provider.ErrAuth:
+		s.rotator.Disable(key.ApiKey)
+	case provider.ErrServer:
+		s.rotator.ReportFailure(key)
+	case provider.ErrClient:
+		// The key works; the request itself was at fault
+		s.rotator.ReportSuccess(key)
 	}
-	hash.Write([]byte(fmt.Sprintf("%d", req.MaxTokens)))
-	hash.Write([]byte(fmt.Sprintf("%f", req.Temperature)))
-	return fmt.Sprintf("%x", hash.Sum(nil))
+	return class
 }
 
 func copyHeaders(dst, src http.Header) {
@@ -328,6 +501,10 @@ func (s *Server) handleCommand(w http.ResponseWriter, body []byte) {
 		s.commandHandler.handleAddCommand(w, parts[2:])
 	case "list":
 		s.commandHandler.handleListCommand(w, parts[2:])
+	case "enable":
+		s.commandHandler.handleEnableCommand(w, parts[2:])
+	case "cache":
+		s.commandHandler.handleCacheCommand(w, parts[2:])
 	case "help":
 		s.commandHandler.handleHelpCommand(w)
 	default:
@@ -364,13 +541,60 @@ func (h *CommandHandler) handleListCommand(w http.ResponseWriter, args []string)
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
+	if len(args) >= 1 && args[0] == "arms" {
+		h.arms.write(w)
+		return
+	}
 	if len(args) < 1 || args[0] != "keys" {
-		http.Error(w, "Usage: #roxy list keys", http.StatusBadRequest)
+		http.Error(w, "Usage: #roxy list keys|arms", http.StatusBadRequest)
 		return
 	}
 
 	for _, key := range h.cfg.APIKeys {
-		fmt.Fprintf(w, "Provider: %s, Key: %s...\n", key.Provider, key.Key[:4])
+		fmt.Fprintf(w, "Provider: %s, Key: %s..., State: %s\n", key.Provider, key.Key[:4], h.rotator.KeyState(key.Provider, key.Key))
+	}
+}
+
+func (h *CommandHandler) handleEnableCommand(w http.ResponseWriter, args []string) {
+	if len(args) < 3 || args[0] != "key" {
+		http.Error(w, "Usage: #roxy enable key [provider] [key]", http.StatusBadRequest)
+		return
+	}
+
+	provider := args[1]
+	key := args[2]
+
+	if err := h.rotator.EnableKey(provider, key); err != nil {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+
+	fmt.Fprintf(w, "Enabled key for provider: %s", provider)
+}
+
+func (h *CommandHandler) cacheBackend() string {
+	if h.cfg.Cache.Backend == "" {
+		return cache.Memory
+	}
+	return h.cfg.Cache.Backend
+}
+
+func (h *CommandHandler) handleCacheCommand(w http.ResponseWriter, args []string) {
+	if len(args) < 1 {
+		http.Error(w, "Usage: #roxy cache stats|clear", http.StatusBadRequest)
+		return
+	}
+
+	switch args[0] {
+	case "stats":
+		stats := h.cache.Stats()
+		fmt.Fprintf(w, "Backend: %s, Entries: %d, Bytes: %d, Hits: %d, Misses: %d, Evictions: %d, Expirations: %d, Errors: %d\n",
+			h.cacheBackend(), stats.Entries, stats.Bytes, stats.Hits, stats.Misses, stats.Evictions, stats.Expirations, stats.Errors)
+	case "clear":
+		h.cache.Clear()
+		fmt.Fprint(w, "Cleared response cache")
+	default:
+		http.Error(w, "Usage: #roxy cache stats|clear", http.StatusBadRequest)
 	}
 }
 
@@ -378,7 +602,11 @@ func (h *CommandHandler) handleHelpCommand(w http.ResponseWriter) {
 	helpText := `Available commands:
 #roxy add key [provider] [key] - Add new API key
 #roxy list keys - List configured API keys
+#roxy list arms - List metrics for each target of each model rule
+#roxy cache stats - Show response cache backend, size and hit/miss/eviction/error counters
+#roxy cache clear - Clear all cached responses
+#roxy enable key [provider] [key] - Re-enable a disabled API key
 #roxy help - Show this help message`
-	
+
 	fmt.Fprint(w, helpText)
 }
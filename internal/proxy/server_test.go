@@ -5,9 +5,13 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/CiaranMcAleer/roxy/internal/config"
+	"github.com/CiaranMcAleer/roxy/internal/sse"
 	"github.com/CiaranMcAleer/roxy/internal/testutils"
 )
 
@@ -44,14 +48,10 @@ func TestProxyServer(t *testing.T) {
 			},
 		},
 		Providers: config.ProviderConfig{
-			OpenAI: struct {
-				BaseURL string `yaml:"base_url"`
-			}{
+			OpenAI: config.ProviderEndpoint{
 				BaseURL: mockOpenAI.URL,
 			},
-			Anthropic: struct {
-				BaseURL string `yaml:"base_url"`
-			}{
+			Anthropic: config.ProviderEndpoint{
 				BaseURL: mockAnthropic.URL,
 			},
 		},
@@ -85,7 +85,7 @@ func TestProxyServer(t *testing.T) {
 			requestBody: testutils.MockLLMRequest{
 				Model: "gpt-4",
 				Messages: []testutils.Message{
-					{Role: "user", Content: "Hello"},
+					{Role: "user", Content: "Hello again"},
 				},
 			},
 			expectedModel:  "claude-2",
@@ -121,3 +121,897 @@ func TestProxyServer(t *testing.T) {
 		})
 	}
 }
+
+func newTestServer(t *testing.T, baseURL string) *Server {
+	t.Helper()
+
+	cfg := &config.Config{
+		ListenAddr: ":8080",
+		APIKeys: []config.APIKeyConfig{
+			{
+				Key:      "test-openai-key",
+				Provider: "openai",
+				MaxRPM:   60,
+				MaxTPM:   40000,
+			},
+		},
+	}
+	cfg.Providers.OpenAI.BaseURL = baseURL
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	return server
+}
+
+func TestStreamingPassthrough(t *testing.T) {
+	mockOpenAI := testutils.MockOpenAIStreamServer()
+	defer mockOpenAI.Close()
+
+	server := newTestServer(t, mockOpenAI.URL)
+
+	body := `{"model":"gpt-4","stream":true,"messages":[{"role":"user","content":"Hello"}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	server.handleProxy(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected event stream content type, got %q", ct)
+	}
+	if !w.Flushed {
+		t.Error("Expected the response to be flushed while streaming")
+	}
+
+	var content strings.Builder
+	reader := sse.NewReader(w.Body)
+	done := false
+	for {
+		ev, err := reader.Next()
+		if err != nil {
+			break
+		}
+		if ev.Data == "[DONE]" {
+			done = true
+			continue
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(ev.Data), &chunk); err != nil {
+			t.Fatalf("Failed to decode chunk %q: %v", ev.Data, err)
+		}
+		content.WriteString(chunk.Choices[0].Delta.Content)
+	}
+
+	if !done {
+		t.Error("Expected stream to end with [DONE]")
+	}
+	if content.String() != "Mock streamed response" {
+		t.Errorf("Unexpected streamed content: %q", content.String())
+	}
+
+	// The usage chunk Roxy asked for is accounted but not relayed, since
+	// this client didn't request it
+	key, err := server.rotator.GetKey("openai")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer server.rotator.Release(key, 0)
+	if used := server.rotator.TokenUsage(key.ApiKey); used != 53 {
+		t.Errorf("Expected 53 streamed tokens to be accounted, got %d", used)
+	}
+}
+
+func TestStreamingClientDisconnect(t *testing.T) {
+	mockUpstream, cancelled := testutils.MockSlowStreamServer()
+	defer mockUpstream.Close()
+
+	server := newTestServer(t, mockUpstream.URL)
+	ts := httptest.NewServer(server.httpServer.Handler)
+	defer ts.Close()
+
+	body := `{"model":"gpt-4","stream":true,"messages":[{"role":"user","content":"Hello"}]}`
+	resp, err := http.Post(ts.URL+"/v1/chat/completions", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+
+	ev, err := sse.NewReader(resp.Body).Next()
+	if err != nil {
+		t.Fatalf("Failed to read first event: %v", err)
+	}
+	if !strings.Contains(ev.Data, "first") {
+		t.Errorf("Unexpected first event: %q", ev.Data)
+	}
+	resp.Body.Close()
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Upstream request was not cancelled after client disconnect")
+	}
+}
+
+func TestLosslessForwarding(t *testing.T) {
+	mockUpstream := testutils.MockEchoServer()
+	defer mockUpstream.Close()
+
+	server := newTestServer(t, mockUpstream.URL)
+	server.cfg.ModelRules = []config.ModelRule{
+		{
+			SourceModel:     "gpt-4",
+			TargetModels:    []string{"gpt-4o"},
+			SelectionPolicy: "roundrobin",
+		},
+	}
+
+	body := `{
+		"model": "gpt-4",
+		"messages": [
+			{"role": "system", "content": "Answer with <json> only"},
+			{"role": "user", "content": [
+				{"type": "text", "text": "What is in this image?"},
+				{"type": "image_url", "image_url": {"url": "data:image/png;base64,AAAA"}}
+			]},
+			{"role": "assistant", "content": null, "tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "lookup", "arguments": "{}"}}]}
+		],
+		"tools": [{"type": "function", "function": {"name": "lookup", "parameters": {"type": "object"}}}],
+		"tool_choice": "auto",
+		"response_format": {"type": "json_object"},
+		"top_p": 0.1,
+		"stop": ["\n\n"],
+		"seed": 12345678901234567,
+		"n": 2,
+		"user": "user-1",
+		"logprobs": true
+	}`
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	server.handleProxy(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var response struct {
+		Echo map[string]json.RawMessage `json:"echo"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	var original map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(body), &original); err != nil {
+		t.Fatalf("Failed to decode original body: %v", err)
+	}
+
+	if string(response.Echo["model"]) != `"gpt-4o"` {
+		t.Errorf("Expected model to be rewritten to gpt-4o, got %s", response.Echo["model"])
+	}
+	for field, value := range original {
+		if field == "model" {
+			continue
+		}
+		if !jsonEqual(t, value, response.Echo[field]) {
+			t.Errorf("Field %s changed in transit: sent %s, forwarded %s", field, value, response.Echo[field])
+		}
+	}
+	if !strings.Contains(string(response.Echo["seed"]), "12345678901234567") {
+		t.Errorf("Large integer lost precision: %s", response.Echo["seed"])
+	}
+}
+
+func jsonEqual(t *testing.T, a, b json.RawMessage) bool {
+	t.Helper()
+
+	var va, vb interface{}
+	if err := json.Unmarshal(a, &va); err != nil {
+		return false
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func TestRateLimitedKeyIsBenched(t *testing.T) {
+	mockUpstream, requests := testutils.MockRateLimitedServer("30")
+	defer mockUpstream.Close()
+
+	server := newTestServer(t, mockUpstream.URL)
+
+	send := func(content string) *httptest.ResponseRecorder {
+		body := `{"model": "gpt-4", "messages": [{"role": "user", "content": "` + content + `"}]}`
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.handleProxy(w, req)
+		return w
+	}
+
+	if w := send("Hello"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 from provider, got %d", w.Code)
+	}
+
+	// The key is benched for the provider's Retry-After, so the next request
+	// is refused without reaching the provider
+	if w := send("Hello again"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 while benched, got %d", w.Code)
+	}
+	if *requests != 1 {
+		t.Errorf("Expected 1 upstream request, got %d", *requests)
+	}
+}
+
+func TestUnavailableKeyIsNotBenched(t *testing.T) {
+	mockUpstream, seen := testutils.MockFlakyServer(3, http.StatusServiceUnavailable)
+	defer mockUpstream.Close()
+
+	keys := []config.APIKeyConfig{
+		{Key: "test-openai-key", Provider: "openai", MaxRPM: 60, MaxTPM: 40000},
+	}
+	server := newRetryTestServer(t, mockUpstream.URL, "", keys, nil)
+
+	if w := sendChat(server, "gpt-4"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status 503 from provider, got %d", w.Code)
+	}
+
+	// A 503 without Retry-After is left to the circuit breaker, so the key
+	// is still used for the next request
+	if w := sendChat(server, "gpt-4o"); w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(*seen) != 4 {
+		t.Errorf("Expected 4 upstream requests, got %d", len(*seen))
+	}
+}
+
+func TestReportedQuotaIsHonoured(t *testing.T) {
+	mockUpstream, requests := testutils.MockQuotaServer(map[string]string{
+		"x-ratelimit-limit-requests":     "60",
+		"x-ratelimit-remaining-requests": "0",
+		"x-ratelimit-reset-requests":     "20s",
+	})
+	defer mockUpstream.Close()
+
+	server := newTestServer(t, mockUpstream.URL)
+
+	send := func(content string) *httptest.ResponseRecorder {
+		body := `{"model": "gpt-4", "messages": [{"role": "user", "content": "` + content + `"}]}`
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.handleProxy(w, req)
+		return w
+	}
+
+	if w := send("Hello"); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The provider said the key has no requests left, even though max_rpm
+	// would allow more
+	if w := send("Hello again"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 with the quota exhausted, got %d", w.Code)
+	}
+	if *requests != 1 {
+		t.Errorf("Expected 1 upstream request, got %d", *requests)
+	}
+}
+
+func TestRejectedKeyIsDisabled(t *testing.T) {
+	mockUpstream, requests := testutils.MockUnauthorizedServer()
+	defer mockUpstream.Close()
+
+	server := newTestServer(t, mockUpstream.URL)
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.handleProxy(w, req)
+		return w
+	}
+
+	if w := send(`{"model": "gpt-4", "messages": [{"role": "user", "content": "Hello"}]}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401 from provider, got %d", w.Code)
+	}
+	if w := send(`{"model": "gpt-4", "messages": [{"role": "user", "content": "Hello again"}]}`); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 with the only key disabled, got %d", w.Code)
+	}
+	if *requests != 1 {
+		t.Errorf("Expected 1 upstream request, got %d", *requests)
+	}
+
+	if w := send("#roxy list keys"); !strings.Contains(w.Body.String(), "State: disabled") {
+		t.Errorf("Expected key to be listed as disabled, got %q", w.Body.String())
+	}
+	if w := send("#roxy enable key openai test-openai-key"); w.Code != http.StatusOK {
+		t.Fatalf("Expected enable command to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	send(`{"model": "gpt-4", "messages": [{"role": "user", "content": "Hello once more"}]}`)
+	if *requests != 2 {
+		t.Errorf("Expected the re-enabled key to be used, got %d upstream requests", *requests)
+	}
+}
+
+func TestOpenRouterAndChutesRouting(t *testing.T) {
+	mockOpenRouter := testutils.MockOpenRouterServer()
+	defer mockOpenRouter.Close()
+
+	mockChutes := testutils.MockChutesServer()
+	defer mockChutes.Close()
+
+	cfg := &config.Config{
+		ListenAddr: ":8080",
+		APIKeys: []config.APIKeyConfig{
+			{
+				Key:      "test-openrouter-key",
+				Provider: "openrouter",
+				MaxRPM:   60,
+				MaxTPM:   40000,
+			},
+			{
+				Key:      "test-chutes-key",
+				Provider: "chutes",
+				MaxRPM:   60,
+				MaxTPM:   40000,
+			},
+		},
+	}
+	cfg.Providers.OpenRouter.BaseURL = mockOpenRouter.URL
+	cfg.Providers.OpenRouter.Referer = "https://roxy.example"
+	cfg.Providers.OpenRouter.Title = "Roxy"
+	cfg.Providers.Chutes.BaseURL = mockChutes.URL
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	testCases := []struct {
+		name          string
+		model         string
+		expectedModel string
+	}{
+		{"openrouter", "openrouter/anthropic/claude-3.5-sonnet", "anthropic/claude-3.5-sonnet"},
+		{"chutes", "chutes/deepseek-ai/DeepSeek-V3", "deepseek-ai/DeepSeek-V3"},
+		// The provider's own IDs may start with its name
+		{"openrouter own prefix", "openrouter/openrouter/auto", "openrouter/auto"},
+		{"chutes own prefix", "chutes/chutes/model", "chutes/model"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := `{"model":"` + tc.model + `","messages":[{"role":"user","content":"Hello"}]}`
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			server.handleProxy(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			var response map[string]interface{}
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if model, _ := response["model"].(string); model != tc.expectedModel {
+				t.Errorf("Expected upstream model %s, got %s", tc.expectedModel, model)
+			}
+
+			if tc.name == "openrouter" {
+				if got := w.Header().Get("X-Mock-Referer"); got != "https://roxy.example" {
+					t.Errorf("Expected HTTP-Referer to be forwarded, got %q", got)
+				}
+				if got := w.Header().Get("X-Mock-Title"); got != "Roxy" {
+					t.Errorf("Expected X-Title to be forwarded, got %q", got)
+				}
+			}
+		})
+	}
+}
+
+func TestAnthropicTargetIsTransparent(t *testing.T) {
+	mockAnthropic := testutils.MockAnthropicServer()
+	defer mockAnthropic.Close()
+
+	cfg := &config.Config{
+		ListenAddr: ":8080",
+		APIKeys: []config.APIKeyConfig{
+			{
+				Key:      "test-anthropic-key",
+				Provider: "anthropic",
+				MaxRPM:   60,
+				MaxTPM:   40000,
+			},
+		},
+		ModelRules: []config.ModelRule{
+			{
+				SourceModel:     "gpt-4",
+				TargetModels:    []string{"claude-3-5-sonnet"},
+				SelectionPolicy: "roundrobin",
+			},
+		},
+	}
+	cfg.Providers.Anthropic.BaseURL = mockAnthropic.URL
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	t.Run("non-streaming", func(t *testing.T) {
+		body := `{"model":"gpt-4","messages":[{"role":"system","content":"Be brief."},{"role":"user","content":"Hello"}]}`
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.handleProxy(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var response struct {
+			Object  string `json:"object"`
+			Model   string `json:"model"`
+			Choices []struct {
+				Message struct {
+					Content string `json:"content"`
+				} `json:"message"`
+				FinishReason string `json:"finish_reason"`
+			} `json:"choices"`
+			Usage struct {
+				TotalTokens int `json:"total_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if response.Object != "chat.completion" || response.Model != "claude-3-5-sonnet" {
+			t.Errorf("Expected an OpenAI-shaped completion from claude-3-5-sonnet, got %+v", response)
+		}
+		if len(response.Choices) != 1 || response.Choices[0].Message.Content != "Mock response for: claude-3-5-sonnet" {
+			t.Errorf("Unexpected choices: %+v", response.Choices)
+		}
+		if response.Choices[0].FinishReason != "stop" || response.Usage.TotalTokens != 70 {
+			t.Errorf("Unexpected finish reason or usage: %+v", response)
+		}
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		body := `{"model":"gpt-4","stream":true,"messages":[{"role":"user","content":"Hello"}]}`
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.handleProxy(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var content strings.Builder
+		var finish string
+		done := false
+		reader := sse.NewReader(w.Body)
+		for {
+			ev, err := reader.Next()
+			if err != nil {
+				break
+			}
+			if ev.Name != "" {
+				t.Errorf("Anthropic event name %q leaked to the client", ev.Name)
+			}
+			if ev.Data == "" {
+				continue
+			}
+			if ev.Data == "[DONE]" {
+				done = true
+				continue
+			}
+
+			var chunk struct {
+				Object  string `json:"object"`
+				Choices []struct {
+					Delta struct {
+						Content string `json:"content"`
+					} `json:"delta"`
+					FinishReason *string `json:"finish_reason"`
+				} `json:"choices"`
+			}
+			if err := json.Unmarshal([]byte(ev.Data), &chunk); err != nil {
+				t.Fatalf("Failed to decode chunk %q: %v", ev.Data, err)
+			}
+			if chunk.Object != "chat.completion.chunk" {
+				t.Errorf("Expected chat.completion.chunk, got %q", chunk.Object)
+			}
+			content.WriteString(chunk.Choices[0].Delta.Content)
+			if chunk.Choices[0].FinishReason != nil {
+				finish = *chunk.Choices[0].FinishReason
+			}
+		}
+
+		if !done {
+			t.Error("Expected stream to end with [DONE]")
+		}
+		if content.String() != "Mock response for: claude-3-5-sonnet" || finish != "stop" {
+			t.Errorf("Unexpected streamed content %q with finish reason %q", content.String(), finish)
+		}
+	})
+}
+
+func TestMessagesEndpoint(t *testing.T) {
+	mockOpenAI := testutils.MockEchoServer()
+	defer mockOpenAI.Close()
+
+	mockStream := testutils.MockOpenAIStreamServer()
+	defer mockStream.Close()
+
+	mockAnthropic := testutils.MockAnthropicServer()
+	defer mockAnthropic.Close()
+
+	newMessagesServer := func(openAIURL string, rule config.ModelRule) *httptest.Server {
+		cfg := &config.Config{
+			ListenAddr: ":8080",
+			APIKeys: []config.APIKeyConfig{
+				{Key: "test-openai-key", Provider: "openai", MaxRPM: 60, MaxTPM: 40000},
+				{Key: "test-anthropic-key", Provider: "anthropic", MaxRPM: 60, MaxTPM: 40000},
+			},
+			ModelRules: []config.ModelRule{rule},
+		}
+		cfg.Providers.OpenAI.BaseURL = openAIURL
+		cfg.Providers.Anthropic.BaseURL = mockAnthropic.URL
+
+		server, err := NewServer(cfg)
+		if err != nil {
+			t.Fatalf("Failed to create server: %v", err)
+		}
+		return httptest.NewServer(server.httpServer.Handler)
+	}
+
+	toOpenAI := config.ModelRule{
+		SourceModel:     "claude-3-5-sonnet",
+		TargetModels:    []string{"gpt-4o"},
+		SelectionPolicy: "roundrobin",
+	}
+
+	postMessages := func(t *testing.T, url, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("POST", url+"/v1/messages", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Key", "client-key")
+		req.Header.Set("Anthropic-Version", "2023-06-01")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		return resp
+	}
+
+	t.Run("translated to OpenAI", func(t *testing.T) {
+		ts := newMessagesServer(mockOpenAI.URL, toOpenAI)
+		defer ts.Close()
+
+		resp := postMessages(t, ts.URL, `{"model":"claude-3-5-sonnet","max_tokens":100,"system":"Be brief.","messages":[{"role":"user","content":"Hello"}]}`)
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+
+		var msg struct {
+			Type    string `json:"type"`
+			Role    string `json:"role"`
+			Model   string `json:"model"`
+			Content []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"content"`
+			StopReason string `json:"stop_reason"`
+			Usage      struct {
+				InputTokens  int `json:"input_tokens"`
+				OutputTokens int `json:"output_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if msg.Type != "message" || msg.Role != "assistant" || msg.Model != "gpt-4o" {
+			t.Errorf("Expected a Messages API response from gpt-4o, got %+v", msg)
+		}
+		if len(msg.Content) != 1 || msg.Content[0].Text != "Mock response for: gpt-4o" {
+			t.Errorf("Unexpected content: %+v", msg.Content)
+		}
+		if msg.StopReason != "end_turn" || msg.Usage.InputTokens != 50 || msg.Usage.OutputTokens != 20 {
+			t.Errorf("Unexpected stop reason or usage: %+v", msg)
+		}
+	})
+
+	t.Run("streamed from OpenAI", func(t *testing.T) {
+		ts := newMessagesServer(mockStream.URL, toOpenAI)
+		defer ts.Close()
+
+		resp := postMessages(t, ts.URL, `{"model":"claude-3-5-sonnet","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"Hello"}]}`)
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+
+		var names []string
+		var text strings.Builder
+		reader := sse.NewReader(resp.Body)
+		for {
+			ev, err := reader.Next()
+			if err != nil {
+				break
+			}
+			names = append(names, ev.Name)
+
+			var data struct {
+				Delta struct {
+					Text string `json:"text"`
+				} `json:"delta"`
+			}
+			json.Unmarshal([]byte(ev.Data), &data)
+			if ev.Name == "content_block_delta" {
+				text.WriteString(data.Delta.Text)
+			}
+		}
+
+		want := []string{"message_start", "content_block_start", "content_block_delta", "content_block_delta",
+			"content_block_delta", "content_block_stop", "message_delta", "message_stop"}
+		if strings.Join(names, ",") != strings.Join(want, ",") {
+			t.Errorf("Unexpected event sequence:\n got %v\nwant %v", names, want)
+		}
+		if text.String() != "Mock streamed response" {
+			t.Errorf("Unexpected streamed text: %q", text.String())
+		}
+	})
+
+	t.Run("native to Anthropic", func(t *testing.T) {
+		ts := newMessagesServer(mockOpenAI.URL, config.ModelRule{
+			SourceModel:     "claude-3-opus",
+			TargetModels:    []string{"claude-3-5-sonnet"},
+			SelectionPolicy: "roundrobin",
+		})
+		defer ts.Close()
+
+		resp := postMessages(t, ts.URL, `{"model":"claude-3-opus","max_tokens":100,"messages":[{"role":"user","content":[{"type":"text","text":"Hello","cache_control":{"type":"ephemeral"}}]}]}`)
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+
+		var msg struct {
+			ID    string `json:"id"`
+			Type  string `json:"type"`
+			Model string `json:"model"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if msg.Type != "message" || msg.ID != "mock-message-id" || msg.Model != "claude-3-5-sonnet" {
+			t.Errorf("Expected the Anthropic response to pass through, got %+v", msg)
+		}
+	})
+}
+
+func TestPatternRules(t *testing.T) {
+	mockOpenAI, _ := testutils.MockFlakyServer(0, http.StatusInternalServerError)
+	defer mockOpenAI.Close()
+
+	keys := []config.APIKeyConfig{
+		{Key: "test-openai-key", Provider: "openai", MaxRPM: 60, MaxTPM: 40000},
+	}
+	rules := []config.ModelRule{
+		{SourceModel: "gpt-4-turbo", TargetModels: []string{"gpt-4.1"}, SelectionPolicy: "fallback"},
+		{SourceModel: `re:^gpt-4-(\d{4})$`, TargetModels: []string{"gpt-4o-${1}"}, SelectionPolicy: "fallback"},
+		{SourceModel: "gpt-4*", TargetModels: []string{"gpt-4o"}, SelectionPolicy: "fallback"},
+	}
+	server := newRetryTestServer(t, mockOpenAI.URL, "", keys, rules)
+
+	testCases := []struct {
+		model    string
+		expected string
+	}{
+		{model: "gpt-4-turbo", expected: "gpt-4.1"},
+		{model: "gpt-4-0613", expected: "gpt-4o-0613"},
+		{model: "gpt-4-32k", expected: "gpt-4o"},
+		{model: "gpt-3.5-turbo", expected: "gpt-3.5-turbo"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.model, func(t *testing.T) {
+			w := sendChat(server, tc.model)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			var resp struct {
+				Model string `json:"model"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp.Model != tc.expected {
+				t.Errorf("Expected model %s, got %s", tc.expected, resp.Model)
+			}
+		})
+	}
+}
+
+func TestUnmappedPatternTarget(t *testing.T) {
+	mockOpenAI, _ := testutils.MockFlakyServer(0, http.StatusInternalServerError)
+	defer mockOpenAI.Close()
+
+	keys := []config.APIKeyConfig{
+		{Key: "test-openai-key", Provider: "openai", MaxRPM: 60, MaxTPM: 40000},
+	}
+	rules := []config.ModelRule{
+		{SourceModel: `re:^local-(\w+)$`, TargetModels: []string{"${1}-chat"}, SelectionPolicy: "fallback"},
+	}
+	server := newRetryTestServer(t, mockOpenAI.URL, "", keys, rules)
+
+	w := sendChat(server, "local-mini")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "mini-chat") {
+		t.Errorf("Expected the error to name mini-chat, got %q", w.Body.String())
+	}
+}
+
+func TestExplicitProviders(t *testing.T) {
+	mockOpenAI, _ := testutils.MockFlakyServer(0, http.StatusInternalServerError)
+	defer mockOpenAI.Close()
+	mockAnthropic := testutils.MockAnthropicServer()
+	defer mockAnthropic.Close()
+	mockChutes := testutils.MockChutesServer()
+	defer mockChutes.Close()
+
+	cfg := &config.Config{
+		ListenAddr: ":8080",
+		APIKeys: []config.APIKeyConfig{
+			{Key: "test-openai-key", Provider: "openai", MaxRPM: 60, MaxTPM: 40000},
+			{Key: "test-anthropic-key", Provider: "anthropic", MaxRPM: 60, MaxTPM: 40000},
+			{Key: "test-chutes-key", Provider: "chutes", MaxRPM: 60, MaxTPM: 40000},
+		},
+		ModelRules: []config.ModelRule{
+			{SourceModel: "gpt-4", TargetModels: []string{"anthropic/claude-3-5-sonnet"}, SelectionPolicy: "fallback"},
+		},
+		ModelProviders: map[string]string{"mistral-*": "chutes"},
+	}
+	cfg.Providers.OpenAI.BaseURL = mockOpenAI.URL
+	cfg.Providers.Anthropic.BaseURL = mockAnthropic.URL
+	cfg.Providers.Chutes.BaseURL = mockChutes.URL
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	testCases := []struct {
+		model          string
+		expectedStatus int
+		expectedModel  string
+	}{
+		{model: "gpt-4", expectedStatus: http.StatusOK, expectedModel: "claude-3-5-sonnet"},
+		{model: "mistral-large", expectedStatus: http.StatusOK, expectedModel: "mistral-large"},
+		{model: "openai/o1-mini", expectedStatus: http.StatusOK, expectedModel: "o1-mini"},
+		{model: "llama-3-70b", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.model, func(t *testing.T) {
+			w := sendChat(server, tc.model)
+			if w.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tc.expectedStatus, w.Code, w.Body.String())
+			}
+			if tc.expectedModel == "" {
+				if !strings.Contains(w.Body.String(), "No provider configured for model: "+tc.model) {
+					t.Errorf("Unexpected error message: %s", w.Body.String())
+				}
+				return
+			}
+
+			var resp struct {
+				Model string `json:"model"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp.Model != tc.expectedModel {
+				t.Errorf("Expected model %s, got %s", tc.expectedModel, resp.Model)
+			}
+		})
+	}
+}
+
+func TestCacheCommands(t *testing.T) {
+	mockOpenAI, seen := testutils.MockFlakyServer(0, http.StatusInternalServerError)
+	defer mockOpenAI.Close()
+
+	keys := []config.APIKeyConfig{
+		{Key: "test-openai-key", Provider: "openai", MaxRPM: 60, MaxTPM: 40000},
+	}
+	server := newRetryTestServer(t, mockOpenAI.URL, "", keys, nil)
+	defer server.cache.Close()
+
+	command := func(cmd string) string {
+		w := httptest.NewRecorder()
+		server.handleProxy(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(cmd)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", cmd, w.Code, w.Body.String())
+		}
+		return w.Body.String()
+	}
+
+	sendChat(server, "gpt-4o")
+	sendChat(server, "gpt-4o")
+	if len(*seen) != 1 {
+		t.Errorf("Expected the second request to be served from the cache, got %d provider requests", len(*seen))
+	}
+	if stats := command("#roxy cache stats"); !strings.Contains(stats, "Entries: 1,") || !strings.Contains(stats, "Hits: 1, Misses: 1,") {
+		t.Errorf("Unexpected cache stats: %s", stats)
+	}
+
+	command("#roxy cache clear")
+	if stats := command("#roxy cache stats"); !strings.Contains(stats, "Entries: 0,") {
+		t.Errorf("Expected an empty cache, got %s", stats)
+	}
+	sendChat(server, "gpt-4o")
+	if len(*seen) != 2 {
+		t.Errorf("Expected a provider request after clearing the cache, got %d", len(*seen))
+	}
+}
+
+func TestSharedRedisCache(t *testing.T) {
+	mockOpenAI, seen := testutils.MockFlakyServer(0, http.StatusInternalServerError)
+	defer mockOpenAI.Close()
+	redis := testutils.MockRedisServer("")
+	defer redis.Close()
+
+	newServer := func() *Server {
+		cfg := &config.Config{
+			ListenAddr: ":8080",
+			APIKeys: []config.APIKeyConfig{
+				{Key: "test-openai-key", Provider: "openai", MaxRPM: 60, MaxTPM: 40000},
+			},
+			Cache: config.CacheConfig{Backend: "redis", Redis: config.RedisConfig{Addr: redis.Addr}},
+		}
+		cfg.Providers.OpenAI.BaseURL = mockOpenAI.URL
+
+		server, err := NewServer(cfg)
+		if err != nil {
+			t.Fatalf("Failed to create server: %v", err)
+		}
+		t.Cleanup(func() { server.cache.Close() })
+		return server
+	}
+	first, second := newServer(), newServer()
+
+	if w := sendChat(first, "gpt-4o"); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w := sendChat(second, "gpt-4o")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "gpt-4o") {
+		t.Errorf("Expected the cached response, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(*seen) != 1 {
+		t.Errorf("Expected the second proxy to be served from the shared cache, got %d provider requests", len(*seen))
+	}
+
+	cfg := &config.Config{
+		ListenAddr: ":8080",
+		Cache:      config.CacheConfig{Backend: "redis", Redis: config.RedisConfig{Addr: "127.0.0.1:1"}},
+	}
+	if _, err := NewServer(cfg); err == nil {
+		t.Error("Expected an unreachable cache server to fail startup")
+	}
+}
@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/CiaranMcAleer/roxy/internal/provider"
+)
+
+// shadowResponse describes one response in a shadow record.
+type shadowResponse struct {
+	Model            string          `json:"model"`
+	Provider         string          `json:"provider"`
+	Status           int             `json:"status,omitempty"`
+	LatencyMs        int64           `json:"latency_ms"`
+	PromptTokens     int             `json:"prompt_tokens"`
+	CompletionTokens int             `json:"completion_tokens"`
+	Output           json.RawMessage `json:"output,omitempty"` // The first choice's message
+	Error            string          `json:"error,omitempty"`
+}
+
+// shadowRecord pairs the response the client got with a candidate model's
+// response to the same request. One is written per candidate.
+type shadowRecord struct {
+	Time        time.Time       `json:"time"`
+	SourceModel string          `json:"source_model"`
+	Request     json.RawMessage `json:"request"`
+	Primary     shadowResponse  `json:"primary"`
+	Shadow      shadowResponse  `json:"shadow"`
+}
+
+// shadower mirrors sampled requests to candidate models and appends the
+// results to each rule's output file.
+type shadower struct {
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	files  map[string]*os.File
+	random func() float64
+}
+
+func newShadower() *shadower {
+	return &shadower{
+		files:  make(map[string]*os.File),
+		random: rand.Float64,
+	}
+}
+
+func (sh *shadower) write(path string, rec shadowRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	f, ok := sh.files[path]
+	if !ok {
+		f, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("opening shadow output: %w", err)
+		}
+		sh.files[path] = f
+	}
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// wait blocks until every mirrored request has been recorded.
+func (sh *shadower) wait() {
+	sh.wg.Wait()
+}
+
+// close waits for mirrored requests and closes the output files.
+func (sh *shadower) close() error {
+	sh.wait()
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	var firstErr error
+	for path, f := range sh.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(sh.files, path)
+	}
+	return firstErr
+}
+
+// mirror samples a request that a's provider answered successfully with
+// body and, if it is picked, sends it to the rule's shadow models in the
+// background. The client's response is never held up or changed. Streamed
+// requests are mirrored unstreamed, once the stream is over; body is nil
+// for them, so the message streamed to the client isn't recorded.
+func (s *Server) mirror(r *http.Request, req *LLMRequest, a *attempt, body []byte, usage provider.Usage) {
+	rule := s.ruleFor(req.Model)
+	if rule == nil || len(rule.Shadow.Models) == 0 {
+		return
+	}
+	if s.shadow.random() >= rule.Shadow.SampleRate {
+		return
+	}
+	shadowed, err := req.unstreamed()
+	if err != nil {
+		log.Printf("Error mirroring streamed request: %v", err)
+		return
+	}
+
+	primary := shadowResponse{
+		Model:            a.model,
+		Provider:         a.provider.Name(),
+		Status:           http.StatusOK,
+		LatencyMs:        time.Since(a.start).Milliseconds(),
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		Output:           firstMessage(a.provider, body),
+	}
+
+	// The mirrored requests outlive the client's
+	shadowReq := r.Clone(context.WithoutCancel(r.Context()))
+	shadow := rule.Shadow
+	for _, model := range shadow.Models {
+		s.shadow.wg.Add(1)
+		go func() {
+			defer s.shadow.wg.Done()
+
+			rec := shadowRecord{
+				Time:        time.Now().UTC(),
+				SourceModel: req.Model,
+				Request:     json.RawMessage(req.body),
+				Primary:     primary,
+				Shadow:      s.shadowSend(shadowReq, shadowed, model),
+			}
+			if err := s.shadow.write(shadow.Output, rec); err != nil {
+				log.Printf("Error recording shadow response: %v", err)
+			}
+		}()
+	}
+}
+
+// shadowSend sends req to a shadow model once, without retries. Only the
+// quota the provider reports is recorded against the key: a candidate's
+// failures must not bench, disable or trip the circuits of keys that live
+// traffic depends on.
+func (s *Server) shadowSend(r *http.Request, req *LLMRequest, model string) shadowResponse {
+	result := shadowResponse{Model: model, Provider: s.providerOf(model)}
+
+	p, ok := s.providers.Get(result.Provider)
+	if !ok {
+		result.Error = "no provider configured for model"
+		return result
+	}
+
+	estimate := req.estimateTokens()
+	key, err := s.rotator.Reserve(p.Name(), estimate)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	start := time.Now()
+	proxyReq, err := s.providerRequest(r, req, p, model, key)
+	var resp *http.Response
+	if err == nil {
+		resp, err = s.roundTrip(proxyReq, s.timeoutsFor(p.Name(), req.Model))
+	}
+	if err != nil {
+		s.rotator.Settle(key, estimate, 0)
+		result.LatencyMs = time.Since(start).Milliseconds()
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	s.rotator.ReportHeaders(key.ApiKey, resp.Header)
+	body, err := io.ReadAll(resp.Body)
+	result.Status = resp.StatusCode
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		s.rotator.Settle(key, estimate, 0)
+		result.Error = err.Error()
+		return result
+	}
+	if resp.StatusCode != http.StatusOK {
+		s.rotator.Settle(key, estimate, 0)
+		result.Error = string(body)
+		return result
+	}
+
+	usage := p.ParseUsage(body)
+	s.rotator.Settle(key, estimate, usedTokens(usage, estimate))
+	result.PromptTokens = usage.PromptTokens
+	result.CompletionTokens = usage.CompletionTokens
+	result.Output = firstMessage(p, body)
+	return result
+}
+
+// firstMessage returns the message of the first choice in a successful
+// response body from p, in the OpenAI shape.
+func firstMessage(p provider.Provider, body []byte) json.RawMessage {
+	chat, err := p.TranslateResponse(body)
+	if err != nil {
+		return nil
+	}
+
+	var doc struct {
+		Choices []struct {
+			Message json.RawMessage `json:"message"`
+		} `json:"choices"`
+	}
+	if json.Unmarshal(chat, &doc) != nil || len(doc.Choices) == 0 {
+		return nil
+	}
+	return doc.Choices[0].Message
+}
@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CiaranMcAleer/roxy/internal/config"
+	"github.com/CiaranMcAleer/roxy/internal/rotation"
+	"github.com/CiaranMcAleer/roxy/internal/testutils"
+)
+
+func newShadowTestServer(t *testing.T, shadow config.ShadowConfig) (*Server, func()) {
+	t.Helper()
+
+	mockOpenAI, _ := testutils.MockFlakyServer(0, http.StatusInternalServerError)
+	mockOpenRouter := testutils.MockDelayedServer(200 * time.Millisecond)
+	mockChutes, _ := testutils.MockFlakyServer(100, http.StatusInternalServerError)
+
+	cfg := &config.Config{
+		ListenAddr: ":8080",
+		APIKeys: []config.APIKeyConfig{
+			{Key: "test-openai-key", Provider: "openai", MaxRPM: 60, MaxTPM: 40000},
+			{Key: "test-openrouter-key", Provider: "openrouter", MaxRPM: 60, MaxTPM: 40000},
+			{Key: "test-chutes-key", Provider: "chutes", MaxRPM: 60, MaxTPM: 40000},
+		},
+		ModelRules: []config.ModelRule{
+			{SourceModel: "gpt-4", TargetModels: []string{"gpt-4o"}, SelectionPolicy: "fallback", Shadow: shadow},
+		},
+	}
+	cfg.Providers.OpenAI.BaseURL = mockOpenAI.URL
+	cfg.Providers.OpenRouter.BaseURL = mockOpenRouter.URL
+	cfg.Providers.Chutes.BaseURL = mockChutes.URL
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	return server, func() {
+		server.shadow.close()
+		mockOpenAI.Close()
+		mockOpenRouter.Close()
+		mockChutes.Close()
+	}
+}
+
+func TestShadowTraffic(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "shadow.jsonl")
+	server, cleanup := newShadowTestServer(t, config.ShadowConfig{
+		Models:     []string{"openrouter/candidate", "chutes/failing"},
+		SampleRate: 1,
+		Output:     output,
+	})
+	defer cleanup()
+
+	start := time.Now()
+	w := sendChat(server, "gpt-4")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+		t.Errorf("Expected the client response before the slow shadow model's, took %v", elapsed)
+	}
+
+	server.shadow.wait()
+
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatalf("Failed to open shadow output: %v", err)
+	}
+	defer f.Close()
+
+	records := make(map[string]shadowRecord)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec shadowRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("Failed to decode shadow record: %v", err)
+		}
+		records[rec.Shadow.Model] = rec
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 shadow records, got %d", len(records))
+	}
+
+	for model, rec := range records {
+		if rec.SourceModel != "gpt-4" {
+			t.Errorf("%s: expected source model gpt-4, got %s", model, rec.SourceModel)
+		}
+		if rec.Primary.Model != "gpt-4o" || rec.Primary.Status != http.StatusOK || len(rec.Primary.Output) == 0 {
+			t.Errorf("%s: unexpected primary response: %+v", model, rec.Primary)
+		}
+		if !strings.Contains(string(rec.Request), "Hello") {
+			t.Errorf("%s: expected the request to be recorded, got %s", model, rec.Request)
+		}
+	}
+
+	candidate := records["openrouter/candidate"].Shadow
+	if candidate.Status != http.StatusOK || len(candidate.Output) == 0 || candidate.Error != "" {
+		t.Errorf("Unexpected candidate response: %+v", candidate)
+	}
+	if candidate.LatencyMs < 200 {
+		t.Errorf("Expected candidate latency of at least 200ms, got %d", candidate.LatencyMs)
+	}
+
+	failing := records["chutes/failing"].Shadow
+	if failing.Status != http.StatusInternalServerError || failing.Error == "" {
+		t.Errorf("Expected failing candidate to be recorded with its error, got %+v", failing)
+	}
+}
+
+func TestShadowSampling(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "shadow.jsonl")
+	server, cleanup := newShadowTestServer(t, config.ShadowConfig{
+		Models:     []string{"openrouter/candidate"},
+		SampleRate: 0.5,
+		Output:     output,
+	})
+	defer cleanup()
+
+	draws := []float64{0.7, 0.2, 0.9}
+	server.shadow.random = func() float64 {
+		d := draws[0]
+		draws = draws[1:]
+		return d
+	}
+
+	for _, content := range []string{"one", "two", "three"} {
+		body := `{"model": "gpt-4", "messages": [{"role": "user", "content": "` + content + `"}]}`
+		w := httptest.NewRecorder()
+		server.handleProxy(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	server.shadow.wait()
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Failed to read shadow output: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"two"`) {
+		t.Errorf("Expected only the second request to be mirrored, got %q", lines)
+	}
+}
+
+func TestShadowFailuresSpareLiveKeys(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusInternalServerError} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			mockOpenAI, models := testutils.MockModelFailureServer("gpt-4o-mini", status)
+			defer mockOpenAI.Close()
+
+			keys := []config.APIKeyConfig{
+				{Key: "test-openai-key", Provider: "openai", MaxRPM: 600, MaxTPM: 400000},
+			}
+			rules := []config.ModelRule{
+				{SourceModel: "gpt-4", TargetModels: []string{"gpt-4o"}, SelectionPolicy: "fallback", Shadow: config.ShadowConfig{
+					Models:     []string{"gpt-4o-mini"},
+					SampleRate: 1,
+					Output:     filepath.Join(t.TempDir(), "shadow.jsonl"),
+				}},
+			}
+			server := newRetryTestServer(t, mockOpenAI.URL, "", keys, rules)
+			server.rotator.SetBreakerSettings(rotation.BreakerSettings{KeyFailures: 2, ProviderFailures: 2})
+			defer server.shadow.close()
+
+			// Enough failed candidates to trip the breakers, were they counted
+			for i := 0; i < 3; i++ {
+				body := fmt.Sprintf(`{"model": "gpt-4", "messages": [{"role": "user", "content": "Hello %d"}]}`, i)
+				w := httptest.NewRecorder()
+				server.handleProxy(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body)))
+				if w.Code != http.StatusOK {
+					t.Fatalf("Request %d: expected status 200, got %d: %s", i, w.Code, w.Body.String())
+				}
+				server.shadow.wait()
+			}
+
+			if n := len(*models); n != 6 {
+				t.Errorf("Expected 3 live and 3 shadow requests, got %d: %v", n, *models)
+			}
+			if state := server.rotator.KeyState("openai", "test-openai-key"); state != "closed" {
+				t.Errorf("Expected the key to stay closed, got %s", state)
+			}
+			if !server.rotator.HasKey("openai") {
+				t.Error("Expected the key to stay available to live traffic")
+			}
+		})
+	}
+}
+
+func TestShadowStreamedRequest(t *testing.T) {
+	gate := make(chan struct{})
+	close(gate)
+	mockOpenAI, _ := testutils.MockGatedServer(gate)
+	defer mockOpenAI.Close()
+	mockOpenRouter, _ := testutils.MockGatedServer(gate)
+	defer mockOpenRouter.Close()
+
+	output := filepath.Join(t.TempDir(), "shadow.jsonl")
+	cfg := &config.Config{
+		ListenAddr: ":8080",
+		APIKeys: []config.APIKeyConfig{
+			{Key: "test-openai-key", Provider: "openai", MaxRPM: 60, MaxTPM: 40000},
+			{Key: "test-openrouter-key", Provider: "openrouter", MaxRPM: 60, MaxTPM: 40000},
+		},
+		ModelRules: []config.ModelRule{
+			{SourceModel: "gpt-4", TargetModels: []string{"gpt-4o"}, SelectionPolicy: "fallback", Shadow: config.ShadowConfig{
+				Models:     []string{"openrouter/candidate"},
+				SampleRate: 1,
+				Output:     output,
+			}},
+		},
+	}
+	cfg.Providers.OpenAI.BaseURL = mockOpenAI.URL
+	cfg.Providers.OpenRouter.BaseURL = mockOpenRouter.URL
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer server.shadow.close()
+
+	body := `{"model": "gpt-4", "stream": true, "messages": [{"role": "user", "content": "Hello"}]}`
+	w := httptest.NewRecorder()
+	server.handleProxy(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body)))
+	if !strings.Contains(w.Body.String(), "[DONE]") {
+		t.Fatalf("Expected the stream to be relayed, got %q", w.Body.String())
+	}
+	server.shadow.wait()
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Failed to read shadow output: %v", err)
+	}
+	var rec shadowRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatalf("Failed to decode shadow record: %v", err)
+	}
+	if rec.Primary.Model != "gpt-4o" || rec.Primary.Status != http.StatusOK {
+		t.Errorf("Unexpected primary response: %+v", rec.Primary)
+	}
+	// The candidate is asked for the whole response, so its message is recorded
+	if rec.Shadow.Status != http.StatusOK || len(rec.Shadow.Output) == 0 {
+		t.Errorf("Expected an unstreamed candidate response, got %+v", rec.Shadow)
+	}
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/CiaranMcAleer/roxy/internal/provider"
+	"github.com/CiaranMcAleer/roxy/internal/sse"
+)
+
+func isEventStream(resp *http.Response) bool {
+	return strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+}
+
+// streamResponse relays an upstream event stream to the client through
+// translator, flushing after every event so tokens reach the client as soon
+// as they arrive. It returns when the upstream stream ends or the client
+// goes away.
+func streamResponse(w http.ResponseWriter, resp *http.Response, translator provider.StreamTranslator) error {
+	flusher, _ := w.(http.Flusher)
+
+	copyHeaders(w.Header(), resp.Header)
+	w.Header().Del("Content-Length")
+	w.WriteHeader(resp.StatusCode)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	reader := sse.NewReader(resp.Body)
+	for {
+		ev, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		events := translator.Translate(ev)
+		for _, out := range events {
+			if err := sse.Write(w, out); err != nil {
+				return err
+			}
+		}
+		if flusher != nil && len(events) > 0 {
+			flusher.Flush()
+		}
+	}
+}
+
+// nativeStream relays events unchanged while still letting the provider's
+// translator observe them for usage accounting.
+type nativeStream struct {
+	provider.StreamTranslator
+}
+
+func (n nativeStream) Translate(ev sse.Event) []sse.Event {
+	n.StreamTranslator.Translate(ev)
+	return []sse.Event{ev}
+}
+
+// chainedStream feeds the output of one translator into another. Usage is
+// taken from the first, which sees the provider's own events.
+type chainedStream struct {
+	first, second provider.StreamTranslator
+}
+
+func (c chainedStream) Translate(ev sse.Event) []sse.Event {
+	var out []sse.Event
+	for _, mid := range c.first.Translate(ev) {
+		out = append(out, c.second.Translate(mid)...)
+	}
+	return out
+}
+
+func (c chainedStream) Usage() provider.Usage {
+	return c.first.Usage()
+}
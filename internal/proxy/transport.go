@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/CiaranMcAleer/roxy/internal/config"
+)
+
+// Timeouts used when neither the model rule nor the provider sets one.
+// There is no default total timeout: long generations are bounded by the
+// first byte and idle timeouts instead.
+const (
+	defaultConnectTimeout   = 10 * time.Second
+	defaultFirstByteTimeout = 2 * time.Minute
+	defaultIdleTimeout      = time.Minute
+)
+
+var (
+	errFirstByteTimeout = fmt.Errorf("provider did not respond in time: %w", context.DeadlineExceeded)
+	errIdleTimeout      = fmt.Errorf("provider response stalled: %w", context.DeadlineExceeded)
+)
+
+type timeouts struct {
+	connect   time.Duration
+	firstByte time.Duration
+	total     time.Duration
+	idle      time.Duration
+}
+
+// resolveTimeouts takes each timeout from the first layer that sets it,
+// falling back to the defaults.
+func resolveTimeouts(layers ...config.Timeouts) timeouts {
+	pick := func(get func(config.Timeouts) int, def time.Duration) time.Duration {
+		for _, l := range layers {
+			if sec := get(l); sec > 0 {
+				return time.Duration(sec) * time.Second
+			}
+		}
+		return def
+	}
+
+	return timeouts{
+		connect:   pick(func(t config.Timeouts) int { return t.ConnectSec }, defaultConnectTimeout),
+		firstByte: pick(func(t config.Timeouts) int { return t.FirstByteSec }, defaultFirstByteTimeout),
+		total:     pick(func(t config.Timeouts) int { return t.TotalSec }, 0),
+		idle:      pick(func(t config.Timeouts) int { return t.IdleSec }, defaultIdleTimeout),
+	}
+}
+
+// timeoutsFor returns the timeouts for a request for sourceModel sent to
+// the named provider: the model rule's, then the provider's.
+func (s *Server) timeoutsFor(providerName, sourceModel string) timeouts {
+	var rule config.Timeouts
+	if r := s.ruleFor(sourceModel); r != nil {
+		rule = r.Timeouts
+	}
+	return resolveTimeouts(rule, s.cfg.Providers.Endpoint(providerName).Timeouts)
+}
+
+type connectTimeoutKey struct{}
+
+// newTransport returns the transport shared by all provider requests. The
+// connect timeout is taken from each request's context so that one pool of
+// connections can serve providers with different settings.
+func newTransport() *http.Transport {
+	dialer := &net.Dialer{KeepAlive: 30 * time.Second}
+
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if d, ok := ctx.Value(connectTimeoutKey{}).(time.Duration); ok && d > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, d)
+				defer cancel()
+			}
+			return dialer.DialContext(ctx, network, addr)
+		},
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          256,
+		MaxIdleConnsPerHost:   64,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// roundTrip sends proxyReq bounded by t. The request stays bound to its
+// own context, so an abandoned client request still cancels it. The
+// returned body enforces the idle and total timeouts while it is read and
+// must be closed.
+func (s *Server) roundTrip(proxyReq *http.Request, t timeouts) (*http.Response, error) {
+	parent := proxyReq.Context()
+	ctx, cancel := context.WithCancelCause(parent)
+	stop := func() { cancel(nil) }
+	if t.total > 0 {
+		var cancelTotal context.CancelFunc
+		ctx, cancelTotal = context.WithTimeout(ctx, t.total)
+		stop = func() {
+			cancelTotal()
+			cancel(nil)
+		}
+	}
+	ctx = context.WithValue(ctx, connectTimeoutKey{}, t.connect)
+
+	var firstByte *time.Timer
+	if t.firstByte > 0 {
+		firstByte = time.AfterFunc(t.firstByte, func() { cancel(errFirstByteTimeout) })
+	}
+
+	resp, err := s.client.Do(proxyReq.WithContext(ctx))
+	if firstByte != nil {
+		firstByte.Stop()
+	}
+	if err != nil {
+		stop()
+		if parent.Err() == nil {
+			if cause := context.Cause(ctx); cause != nil {
+				return nil, cause
+			}
+		}
+		return nil, err
+	}
+
+	resp.Body = newTimeoutBody(ctx, resp.Body, t.idle, cancel, stop)
+	return resp, nil
+}
+
+// timeoutBody cancels the provider request when a read waits longer than
+// idle for the next chunk.
+type timeoutBody struct {
+	io.ReadCloser
+	ctx   context.Context
+	idle  time.Duration
+	timer *time.Timer
+	stop  func()
+}
+
+func newTimeoutBody(ctx context.Context, body io.ReadCloser, idle time.Duration, cancel context.CancelCauseFunc, stop func()) *timeoutBody {
+	b := &timeoutBody{ReadCloser: body, ctx: ctx, idle: idle, stop: stop}
+	if idle > 0 {
+		b.timer = time.AfterFunc(idle, func() { cancel(errIdleTimeout) })
+	}
+	return b
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	if b.timer != nil {
+		b.timer.Reset(b.idle)
+	}
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		if cause := context.Cause(b.ctx); cause != nil {
+			err = cause
+		}
+	}
+	return n, err
+}
+
+func (b *timeoutBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	err := b.ReadCloser.Close()
+	b.stop()
+	return err
+}
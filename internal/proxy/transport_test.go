@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CiaranMcAleer/roxy/internal/config"
+	"github.com/CiaranMcAleer/roxy/internal/testutils"
+)
+
+func TestTimeoutsFor(t *testing.T) {
+	server := newTestServer(t, "http://unused")
+	server.cfg.Providers.OpenAI.Timeouts = config.Timeouts{ConnectSec: 5, TotalSec: 300}
+	server.cfg.ModelRules = []config.ModelRule{
+		{
+			SourceModel:     "o1",
+			TargetModels:    []string{"gpt-o1"},
+			SelectionPolicy: "fallback",
+			Timeouts:        config.Timeouts{FirstByteSec: 600, TotalSec: 900},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		provider string
+		model    string
+		want     timeouts
+	}{
+		{
+			name:     "defaults",
+			provider: "anthropic",
+			model:    "claude-3",
+			want:     timeouts{connect: defaultConnectTimeout, firstByte: defaultFirstByteTimeout, idle: defaultIdleTimeout},
+		},
+		{
+			name:     "provider",
+			provider: "openai",
+			model:    "gpt-4",
+			want:     timeouts{connect: 5 * time.Second, firstByte: defaultFirstByteTimeout, total: 300 * time.Second, idle: defaultIdleTimeout},
+		},
+		{
+			name:     "rule overrides provider",
+			provider: "openai",
+			model:    "o1",
+			want:     timeouts{connect: 5 * time.Second, firstByte: 600 * time.Second, total: 900 * time.Second, idle: defaultIdleTimeout},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := server.timeoutsFor(tt.provider, tt.model); got != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRoundTripTimeouts(t *testing.T) {
+	server := newTestServer(t, "http://unused")
+
+	t.Run("first byte", func(t *testing.T) {
+		mockUpstream := testutils.MockDelayedServer(5 * time.Second)
+		defer mockUpstream.Close()
+
+		req, _ := http.NewRequest("POST", mockUpstream.URL, strings.NewReader("{}"))
+		start := time.Now()
+		_, err := server.roundTrip(req, timeouts{firstByte: 50 * time.Millisecond})
+		if !errors.Is(err, errFirstByteTimeout) {
+			t.Fatalf("Expected first byte timeout, got %v", err)
+		}
+		if transportFailure(err) != retryTimeout {
+			t.Errorf("Expected timeout to be retryable as %s, got %s", retryTimeout, transportFailure(err))
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Timeout took %v", elapsed)
+		}
+	})
+
+	t.Run("total", func(t *testing.T) {
+		mockUpstream := testutils.MockDelayedServer(5 * time.Second)
+		defer mockUpstream.Close()
+
+		req, _ := http.NewRequest("POST", mockUpstream.URL, strings.NewReader("{}"))
+		_, err := server.roundTrip(req, timeouts{total: 50 * time.Millisecond})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected deadline exceeded, got %v", err)
+		}
+	})
+
+	t.Run("idle between chunks", func(t *testing.T) {
+		mockUpstream, cancelled := testutils.MockSlowStreamServer()
+		defer mockUpstream.Close()
+
+		req, _ := http.NewRequest("POST", mockUpstream.URL, strings.NewReader("{}"))
+		resp, err := server.roundTrip(req, timeouts{idle: 100 * time.Millisecond})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+
+		_, err = io.ReadAll(resp.Body)
+		if !errors.Is(err, errIdleTimeout) {
+			t.Fatalf("Expected idle timeout, got %v", err)
+		}
+
+		select {
+		case <-cancelled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Upstream request was not cancelled after the idle timeout")
+		}
+	})
+
+	t.Run("slow but steady response", func(t *testing.T) {
+		mockUpstream := testutils.MockDelayedServer(100 * time.Millisecond)
+		defer mockUpstream.Close()
+
+		req, _ := http.NewRequest("POST", mockUpstream.URL, strings.NewReader("{}"))
+		resp, err := server.roundTrip(req, timeouts{firstByte: time.Second, idle: time.Second})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if _, err := io.ReadAll(resp.Body); err != nil {
+			t.Errorf("Unexpected error reading body: %v", err)
+		}
+	})
+}
@@ -0,0 +1,224 @@
+package rotation
+
+import (
+	"fmt"
+	"time"
+)
+
+// BreakerSettings controls when key and provider circuits open and how
+// they recover. Zero fields take the defaults.
+type BreakerSettings struct {
+	KeyFailures      int           // Consecutive failures before a key's circuit opens
+	ProviderFailures int           // Consecutive failures before a provider's circuit opens
+	OpenFor          time.Duration // How long a circuit stays open before probing
+	Probes           int           // Concurrent probe requests while half-open
+}
+
+var defaultBreakerSettings = BreakerSettings{
+	KeyFailures:      5,
+	ProviderFailures: 10,
+	OpenFor:          30 * time.Second,
+	Probes:           1,
+}
+
+func (s BreakerSettings) withDefaults() BreakerSettings {
+	if s.KeyFailures <= 0 {
+		s.KeyFailures = defaultBreakerSettings.KeyFailures
+	}
+	if s.ProviderFailures <= 0 {
+		s.ProviderFailures = defaultBreakerSettings.ProviderFailures
+	}
+	if s.OpenFor <= 0 {
+		s.OpenFor = defaultBreakerSettings.OpenFor
+	}
+	if s.Probes <= 0 {
+		s.Probes = defaultBreakerSettings.Probes
+	}
+	return s
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breaker counts consecutive failures. Once open it refuses requests for
+// OpenFor, then half-opens and lets a limited number of probes through:
+// a successful probe closes it again and a failed one re-opens it.
+// Requests let through before it opened don't count towards any of this.
+type breaker struct {
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probes   int
+
+	// trial numbers the half-open periods, so that a probe can be told
+	// from requests sent before it or in an earlier period.
+	trial int
+}
+
+// allow reports whether a request may be sent through the breaker.
+func (b *breaker) allow(now time.Time, s BreakerSettings) bool {
+	switch b.state {
+	case breakerOpen:
+		return !now.Before(b.openedAt.Add(s.OpenFor))
+	case breakerHalfOpen:
+		return b.probes < s.Probes
+	default:
+		return true
+	}
+}
+
+// acquire records a request sent through the breaker, which must have been
+// allowed. Requests sent while half-open are probes; acquire returns the
+// trial a probe belongs to, and zero for any other request.
+func (b *breaker) acquire(now time.Time, s BreakerSettings) int {
+	if b.state == breakerOpen && !now.Before(b.openedAt.Add(s.OpenFor)) {
+		b.state = breakerHalfOpen
+		b.probes = 0
+		b.trial++
+	}
+	if b.state == breakerHalfOpen {
+		b.probes++
+		return b.trial
+	}
+	return 0
+}
+
+// probing reports whether a request acquired in trial is a probe of the
+// current half-open period.
+func (b *breaker) probing(trial int) bool {
+	return b.state == breakerHalfOpen && trial != 0 && trial == b.trial
+}
+
+// done frees the probe slot of a finished request, whatever its outcome.
+func (b *breaker) done(trial int) {
+	if b.probing(trial) && b.probes > 0 {
+		b.probes--
+	}
+}
+
+func (b *breaker) success(trial int) {
+	if b.state == breakerClosed || b.probing(trial) {
+		b.state = breakerClosed
+		b.failures = 0
+		b.probes = 0
+	}
+}
+
+func (b *breaker) failure(now time.Time, threshold, trial int) {
+	switch {
+	case b.state == breakerClosed:
+		b.failures++
+		if b.failures < threshold {
+			return
+		}
+	case !b.probing(trial):
+		return
+	}
+	b.state = breakerOpen
+	b.openedAt = now
+	b.probes = 0
+}
+
+// SetBreakerSettings replaces the circuit breaker thresholds.
+func (kr *KeyRotator) SetBreakerSettings(s BreakerSettings) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	kr.breakerSettings = s.withDefaults()
+}
+
+// ReportSuccess records that the request holding res reached a working
+// provider, closing the key's and the provider's circuits if it was their
+// probe.
+func (kr *KeyRotator) ReportSuccess(res *Reservation) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	res.breaker.success(res.keyTrial)
+	kr.providerBreaker(res.Config.Provider).success(res.providerTrial)
+}
+
+// ReportFailure records that the request holding res failed through no
+// fault of the client, counting towards opening the key's and the
+// provider's circuits.
+func (kr *KeyRotator) ReportFailure(res *Reservation) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	now := kr.now()
+	res.breaker.failure(now, kr.breakerSettings.KeyFailures, res.keyTrial)
+	kr.providerBreaker(res.Config.Provider).failure(now, kr.breakerSettings.ProviderFailures, res.providerTrial)
+}
+
+// Disable takes key out of rotation until it is re-enabled with EnableKey,
+// for keys the provider no longer accepts.
+func (kr *KeyRotator) Disable(key *ApiKey) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	key.disabled = true
+}
+
+// EnableKey puts a disabled key back into rotation with a fresh circuit.
+func (kr *KeyRotator) EnableKey(provider, value string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	for _, key := range kr.keys {
+		if key.Config.Provider == provider && key.Config.Key == value {
+			key.disabled = false
+			key.breaker = breaker{trial: key.breaker.trial}
+			return nil
+		}
+	}
+	return fmt.Errorf("no key %s... for provider: %s", prefix(value), provider)
+}
+
+// KeyState describes a key's circuit for operators: "disabled", "open",
+// "half-open" or "closed".
+func (kr *KeyRotator) KeyState(provider, value string) string {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	for _, key := range kr.keys {
+		if key.Config.Provider == provider && key.Config.Key == value {
+			if key.disabled {
+				return "disabled"
+			}
+			return key.breaker.state.String()
+		}
+	}
+	return ""
+}
+
+func (kr *KeyRotator) providerBreaker(provider string) *breaker {
+	b, ok := kr.providerBreakers[provider]
+	if !ok {
+		b = &breaker{}
+		kr.providerBreakers[provider] = b
+	}
+	return b
+}
+
+func prefix(value string) string {
+	if len(value) > 4 {
+		return value[:4]
+	}
+	return value
+}
@@ -0,0 +1,186 @@
+package rotation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CiaranMcAleer/roxy/internal/config"
+)
+
+func TestKeyCircuitBreaker(t *testing.T) {
+	configs := []config.APIKeyConfig{
+		{Key: "test-key-1", Provider: "openai", MaxRPM: 100, MaxTPM: 10000},
+		{Key: "test-key-2", Provider: "openai", MaxRPM: 100, MaxTPM: 10000},
+	}
+
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	rotator := NewKeyRotator(configs)
+	rotator.now = clock.now
+	rotator.SetBreakerSettings(BreakerSettings{KeyFailures: 2, ProviderFailures: 100, OpenFor: 30 * time.Second})
+	rotator.SetStrategy("openai", LeastRecentlyUsed)
+
+	key1, key2 := rotator.keys[0], rotator.keys[1]
+
+	// Two consecutive failures open the first key's circuit
+	for i := 0; i < 2; i++ {
+		rotator.ReportFailure(&Reservation{ApiKey: key1})
+	}
+	for i := 0; i < 3; i++ {
+		clock.advance(time.Second)
+		key, err := rotator.GetKey("openai")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if key.ApiKey != key2 {
+			t.Errorf("Expected test-key-2 while test-key-1 is open, got %s", key.Config.Key)
+		}
+		rotator.Release(key, 0)
+	}
+	if state := rotator.KeyState("openai", "test-key-1"); state != "open" {
+		t.Errorf("Expected open, got %s", state)
+	}
+
+	// After OpenFor a single probe is let through
+	clock.advance(30 * time.Second)
+	probe, err := rotator.GetKey("openai")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if probe.ApiKey != key1 {
+		t.Fatalf("Expected a probe on test-key-1, got %s", probe.Config.Key)
+	}
+	if next, _ := rotator.GetKey("openai"); next.ApiKey != key2 {
+		t.Error("Expected a second request to avoid the half-open key")
+	} else {
+		rotator.Release(next, 0)
+	}
+
+	// A failed probe re-opens the circuit
+	rotator.ReportFailure(probe)
+	rotator.Release(probe, 0)
+	if state := rotator.KeyState("openai", "test-key-1"); state != "open" {
+		t.Errorf("Expected open after failed probe, got %s", state)
+	}
+
+	// A successful probe closes it
+	clock.advance(30 * time.Second)
+	probe, _ = rotator.GetKey("openai")
+	if probe.ApiKey != key1 {
+		t.Fatalf("Expected a probe on test-key-1, got %v", probe)
+	}
+	rotator.ReportSuccess(probe)
+	rotator.Release(probe, 0)
+	if state := rotator.KeyState("openai", "test-key-1"); state != "closed" {
+		t.Errorf("Expected closed after successful probe, got %s", state)
+	}
+}
+
+func TestRequestFinishingAfterBreakerTrips(t *testing.T) {
+	configs := []config.APIKeyConfig{
+		{Key: "test-key-1", Provider: "openai", MaxRPM: 100, MaxTPM: 10000},
+	}
+
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	rotator := NewKeyRotator(configs)
+	rotator.now = clock.now
+	rotator.SetBreakerSettings(BreakerSettings{KeyFailures: 2, ProviderFailures: 100, OpenFor: 30 * time.Second, Probes: 1})
+
+	// A request sent while the circuit is closed is still under way when
+	// it opens and then half-opens
+	stale, err := rotator.GetKey("openai")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		rotator.ReportFailure(&Reservation{ApiKey: stale.ApiKey})
+	}
+	clock.advance(30 * time.Second)
+	probe, err := rotator.GetKey("openai")
+	if err != nil {
+		t.Fatalf("Expected a probe, got %v", err)
+	}
+
+	// Its outcome neither closes the circuit nor frees the probe's slot
+	rotator.ReportSuccess(stale)
+	rotator.Settle(stale, 0, 0)
+	if state := rotator.KeyState("openai", "test-key-1"); state != "half-open" {
+		t.Errorf("Expected half-open after the earlier request succeeded, got %s", state)
+	}
+	if _, err := rotator.GetKey("openai"); err == nil {
+		t.Error("Expected the probe to still hold the only slot")
+	}
+
+	rotator.ReportSuccess(probe)
+	rotator.Settle(probe, 0, 0)
+	if state := rotator.KeyState("openai", "test-key-1"); state != "closed" {
+		t.Errorf("Expected closed after the probe succeeded, got %s", state)
+	}
+}
+
+func TestProviderCircuitBreaker(t *testing.T) {
+	configs := []config.APIKeyConfig{
+		{Key: "test-key-1", Provider: "openai", MaxRPM: 100, MaxTPM: 10000},
+		{Key: "test-key-2", Provider: "openai", MaxRPM: 100, MaxTPM: 10000},
+		{Key: "test-key-3", Provider: "anthropic", MaxRPM: 100, MaxTPM: 10000},
+	}
+
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	rotator := NewKeyRotator(configs)
+	rotator.now = clock.now
+	rotator.SetBreakerSettings(BreakerSettings{KeyFailures: 100, ProviderFailures: 3, OpenFor: 10 * time.Second})
+
+	// Failures spread over both keys still add up for the provider
+	rotator.ReportFailure(&Reservation{ApiKey: rotator.keys[0]})
+	rotator.ReportFailure(&Reservation{ApiKey: rotator.keys[1]})
+	rotator.ReportFailure(&Reservation{ApiKey: rotator.keys[0]})
+
+	if _, err := rotator.GetKey("openai"); err == nil {
+		t.Error("Expected the provider's circuit to be open")
+	}
+	if _, err := rotator.GetKey("anthropic"); err != nil {
+		t.Errorf("Expected other providers to be unaffected, got %v", err)
+	}
+
+	clock.advance(10 * time.Second)
+	key, err := rotator.GetKey("openai")
+	if err != nil {
+		t.Fatalf("Expected a probe after the open period, got %v", err)
+	}
+	rotator.ReportSuccess(key)
+	rotator.Release(key, 0)
+
+	if _, err := rotator.GetKey("openai"); err != nil {
+		t.Errorf("Expected the provider to recover, got %v", err)
+	}
+}
+
+func TestDisableAndEnableKey(t *testing.T) {
+	configs := []config.APIKeyConfig{
+		{Key: "test-key-1", Provider: "openai", MaxRPM: 100, MaxTPM: 10000},
+	}
+	rotator := NewKeyRotator(configs)
+
+	key, err := rotator.GetKey("openai")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rotator.Disable(key.ApiKey)
+	rotator.Release(key, 0)
+
+	if _, err := rotator.GetKey("openai"); err == nil {
+		t.Error("Expected disabled key to be skipped")
+	}
+	if state := rotator.KeyState("openai", "test-key-1"); state != "disabled" {
+		t.Errorf("Expected disabled, got %s", state)
+	}
+
+	if err := rotator.EnableKey("openai", "unknown"); err == nil {
+		t.Error("Expected error enabling an unknown key")
+	}
+	if err := rotator.EnableKey("openai", "test-key-1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := rotator.GetKey("openai"); err != nil {
+		t.Errorf("Expected re-enabled key to be used, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package rotation
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resetHeaders name the headers providers use to say when an exhausted
+// limit resets. OpenAI sends durations such as "6m0s"; Anthropic sends
+// RFC 3339 timestamps.
+var resetHeaders = []string{
+	"X-Ratelimit-Reset-Requests",
+	"X-Ratelimit-Reset-Tokens",
+	"Anthropic-Ratelimit-Requests-Reset",
+	"Anthropic-Ratelimit-Tokens-Reset",
+	"Anthropic-Ratelimit-Input-Tokens-Reset",
+	"Anthropic-Ratelimit-Output-Tokens-Reset",
+}
+
+// limitHeaders names the headers a provider reports one limit with.
+type limitHeaders struct {
+	limit, remaining, reset string
+}
+
+var requestLimitHeaders = []limitHeaders{
+	{"X-Ratelimit-Limit-Requests", "X-Ratelimit-Remaining-Requests", "X-Ratelimit-Reset-Requests"},
+	{"Anthropic-Ratelimit-Requests-Limit", "Anthropic-Ratelimit-Requests-Remaining", "Anthropic-Ratelimit-Requests-Reset"},
+}
+
+var tokenLimitHeaders = []limitHeaders{
+	{"X-Ratelimit-Limit-Tokens", "X-Ratelimit-Remaining-Tokens", "X-Ratelimit-Reset-Tokens"},
+	{"Anthropic-Ratelimit-Tokens-Limit", "Anthropic-Ratelimit-Tokens-Remaining", "Anthropic-Ratelimit-Tokens-Reset"},
+	{"Anthropic-Ratelimit-Input-Tokens-Limit", "Anthropic-Ratelimit-Input-Tokens-Remaining", "Anthropic-Ratelimit-Input-Tokens-Reset"},
+}
+
+// reportedLimit is a provider's own account of one of a key's limits.
+type reportedLimit struct {
+	known     bool
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+// active reports whether the limit is known and has not yet reset.
+func (l reportedLimit) active(now time.Time) bool {
+	return l.known && now.Before(l.reset)
+}
+
+// parseLimit reads the first of candidates present in h. A limit without
+// a reset time is assumed to cover the usual window.
+func parseLimit(h http.Header, candidates []limitHeaders, now time.Time) reportedLimit {
+	for _, names := range candidates {
+		remaining, err := strconv.Atoi(strings.TrimSpace(h.Get(names.remaining)))
+		if err != nil {
+			continue
+		}
+
+		l := reportedLimit{known: true, remaining: remaining, reset: now.Add(rateWindow)}
+		if limit, err := strconv.Atoi(strings.TrimSpace(h.Get(names.limit))); err == nil {
+			l.limit = limit
+		}
+		if d := parseWait(h.Get(names.reset), now); d > 0 {
+			l.reset = now.Add(d)
+		}
+		return l
+	}
+	return reportedLimit{}
+}
+
+// RetryAfter returns how long a provider asked the caller to wait, as of
+// now, or zero when the response carries no hint. Retry-After wins over
+// the per-limit reset headers; among those the longest wait is used since
+// the response does not say which limit was hit.
+func RetryAfter(h http.Header, now time.Time) time.Duration {
+	if ms, err := strconv.Atoi(h.Get("Retry-After-Ms")); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	if d := parseWait(h.Get("Retry-After"), now); d > 0 {
+		return d
+	}
+
+	var wait time.Duration
+	for _, name := range resetHeaders {
+		if d := parseWait(h.Get(name), now); d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// parseWait reads a wait given as seconds, a Go-style duration, an HTTP
+// date or an RFC 3339 timestamp.
+func parseWait(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Duration(secs * float64(time.Second))
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return d
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t.Sub(now)
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,44 @@
+package rotation
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    time.Duration
+	}{
+		{"none", nil, 0},
+		{"seconds", map[string]string{"Retry-After": "20"}, 20 * time.Second},
+		{"http date", map[string]string{"Retry-After": "Mon, 01 Jan 2024 12:00:45 GMT"}, 45 * time.Second},
+		{"milliseconds", map[string]string{"Retry-After-Ms": "1500", "Retry-After": "2"}, 1500 * time.Millisecond},
+		{"openai resets", map[string]string{
+			"X-Ratelimit-Reset-Requests": "1s",
+			"X-Ratelimit-Reset-Tokens":   "6m0s",
+		}, 6 * time.Minute},
+		{"anthropic reset", map[string]string{"Anthropic-Ratelimit-Tokens-Reset": "2024-01-01T12:00:30Z"}, 30 * time.Second},
+		{"retry-after wins", map[string]string{
+			"Retry-After":              "3",
+			"X-Ratelimit-Reset-Tokens": "6m0s",
+		}, 3 * time.Second},
+		{"garbage", map[string]string{"Retry-After": "soon"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+			if got := RetryAfter(h, now); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -2,37 +2,99 @@ package rotation
 
 import (
 	"fmt"
+	"net/http"
 	"sync"
 	"time"
 
 	"github.com/CiaranMcAleer/roxy/internal/config"
 )
 
+const (
+	// rateWindow is the period RPM and TPM limits are measured over.
+	rateWindow = time.Minute
+
+	// defaultCooldown benches a rate-limited key whose config sets no
+	// cooldown_sec and whose provider gave no retry hint.
+	defaultCooldown = time.Minute
+)
+
 type KeyRotator struct {
-	keys     []*ApiKey
-	mu       sync.RWMutex
-	lastUsed map[string]time.Time
+	keys       []*ApiKey
+	mu         sync.RWMutex
+	lastUsed   map[string]time.Time
+	strategies map[string]Strategy
+	cursors    map[string]int
+	now        func() time.Time
+
+	breakerSettings  BreakerSettings
+	providerBreakers map[string]*breaker
 }
 
 type ApiKey struct {
-	Config     config.APIKeyConfig
-	usageCount int
-	lastUsed   time.Time
+	Config   config.APIKeyConfig
+	lastUsed time.Time
+
+	// inflight and reservedTokens hold capacity for requests that have
+	// been handed a key but not yet reported back, so that concurrent
+	// requests cannot overshoot the key's limits.
+	inflight       int
+	reservedTokens int
+
+	// requestLog records the completed requests within the last
+	// rateWindow, oldest first.
+	requestLog []requestUsage
+
+	// benchedUntil is when a key that was rate limited by its provider may
+	// be handed out again.
+	benchedUntil time.Time
+
+	// currentWeight is the key's running score under the Weighted strategy.
+	currentWeight int
+
+	// reportedRequests and reportedTokens are the remaining quota the
+	// provider last reported for the key, less what has been sent since.
+	// While they are current they take precedence over max_rpm/max_tpm.
+	reportedRequests reportedLimit
+	reportedTokens   reportedLimit
+
+	// breaker trips after repeated failures; disabled keys were rejected
+	// by their provider and stay out of rotation until re-enabled.
+	breaker  breaker
+	disabled bool
+}
+
+// Reservation is one request's hold on a key, from Reserve until it is
+// settled or released.
+type Reservation struct {
+	*ApiKey
+
+	// keyTrial and providerTrial are the half-open periods of the key's
+	// and the provider's circuits the request probes, or zero if it isn't
+	// a probe.
+	keyTrial      int
+	providerTrial int
+}
+
+type requestUsage struct {
+	at     time.Time
+	tokens int
 }
 
 func NewKeyRotator(configs []config.APIKeyConfig) *KeyRotator {
 	keys := make([]*ApiKey, len(configs))
 	for i, cfg := range configs {
-		keys[i] = &ApiKey{
-			Config:     cfg,
-			usageCount: 0,
-			lastUsed:   time.Time{},
-		}
+		keys[i] = &ApiKey{Config: cfg}
 	}
 
 	return &KeyRotator{
-		keys:     keys,
-		lastUsed: make(map[string]time.Time),
+		keys:       keys,
+		lastUsed:   make(map[string]time.Time),
+		strategies: make(map[string]Strategy),
+		cursors:    make(map[string]int),
+		now:        time.Now,
+
+		breakerSettings:  defaultBreakerSettings,
+		providerBreakers: make(map[string]*breaker),
 	}
 }
 
@@ -40,42 +102,237 @@ func (kr *KeyRotator) AddKey(cfg config.APIKeyConfig) {
 	kr.mu.Lock()
 	defer kr.mu.Unlock()
 
-	newKey := &ApiKey{
-		Config:     cfg,
-		usageCount: 0,
-		lastUsed:   time.Time{},
-	}
+	newKey := &ApiKey{Config: cfg}
 
 	kr.keys = append(kr.keys, newKey)
 }
 
-func (kr *KeyRotator) GetKey(provider string) (*ApiKey, error) {
+// GetKey returns a key for provider with room for another request. The
+// request must be reported back with Settle or Release.
+func (kr *KeyRotator) GetKey(provider string) (*Reservation, error) {
+	return kr.Reserve(provider, 0)
+}
+
+// Reserve returns a key for provider with room for another request and for
+// tokens more tokens within its TPM limit, other than the keys in exclude.
+// Both are held against the key until the request is reported back with
+// Settle or Release.
+func (kr *KeyRotator) Reserve(provider string, tokens int, exclude ...*ApiKey) (*Reservation, error) {
 	kr.mu.Lock()
 	defer kr.mu.Unlock()
 
-	now := time.Now()
+	now := kr.now()
+	providerBreaker := kr.providerBreaker(provider)
+	if !providerBreaker.allow(now, kr.breakerSettings) {
+		return nil, fmt.Errorf("circuit open for provider: %s", provider)
+	}
+
+	pool, eligible := kr.eligible(provider, tokens, exclude, now)
+	if len(eligible) == 0 {
+		return nil, fmt.Errorf("no available keys for provider: %s", provider)
+	}
+
+	key := kr.pick(provider, pool, eligible, now)
+	key.inflight++
+	key.reservedTokens += tokens
+	key.lastUsed = now
+	key.reportedRequests.remaining--
+	key.reportedTokens.remaining -= tokens
+	return &Reservation{
+		ApiKey:        key,
+		keyTrial:      key.breaker.acquire(now, kr.breakerSettings),
+		providerTrial: providerBreaker.acquire(now, kr.breakerSettings),
+	}, nil
+}
+
+// HasKey reports whether Reserve would return a key for provider now,
+// without reserving one or changing the state selection depends on.
+func (kr *KeyRotator) HasKey(provider string) bool {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	now := kr.now()
+	if !kr.providerBreaker(provider).allow(now, kr.breakerSettings) {
+		return false
+	}
+	_, eligible := kr.eligible(provider, 0, nil, now)
+	return len(eligible) > 0
+}
+
+// eligible returns provider's keys, and those of them that have room for
+// another request and for tokens more tokens, other than the keys in
+// exclude. The caller must hold the lock.
+func (kr *KeyRotator) eligible(provider string, tokens int, exclude []*ApiKey, now time.Time) (pool, eligible []*ApiKey) {
 	for _, key := range kr.keys {
 		if key.Config.Provider != provider {
 			continue
 		}
+		pool = append(pool, key)
+
+		// Skip keys that are excluded, disabled or failing
+		if excluded(key, exclude) || key.disabled || !key.breaker.allow(now, kr.breakerSettings) {
+			continue
+		}
 
 		// Check if key is within rate limits
-		if now.Sub(key.lastUsed) >= time.Minute {
-			key.usageCount = 0 // Reset counter after a minute
+		if now.Before(key.benchedUntil) {
+			continue
 		}
+		requests, used := key.usage(now)
+		if requests+key.inflight >= key.Config.MaxRPM {
+			continue
+		}
+		if !key.hasTokenCapacity(used, tokens) {
+			continue
+		}
+		if !key.hasReportedCapacity(now, tokens) {
+			continue
+		}
+		eligible = append(eligible, key)
+	}
+	return pool, eligible
+}
+
+// ReportUsage records a completed request on key that used tokens.
+func (kr *KeyRotator) ReportUsage(key *ApiKey, tokens int) {
+	kr.Settle(&Reservation{ApiKey: key}, 0, tokens)
+}
 
-		if key.usageCount < key.Config.MaxRPM {
-			return key, nil
+// Settle records the completed request holding res, which used tokens,
+// replacing the reserved estimate it was handed out with.
+func (kr *KeyRotator) Settle(res *Reservation, reserved, tokens int) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	kr.release(res, reserved)
+
+	now := kr.now()
+	res.lastUsed = now
+	res.requestLog = append(res.requestLog, requestUsage{at: now, tokens: tokens})
+}
+
+// Cooldown benches key after its provider rejected it for rate limiting or
+// overload. The key is skipped for retryAfter when the provider said how
+// long to wait, and for the key's cooldown_sec otherwise.
+func (kr *KeyRotator) Cooldown(key *ApiKey, retryAfter time.Duration) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	wait := retryAfter
+	if wait <= 0 {
+		wait = time.Duration(key.Config.CooldownSec) * time.Second
+	}
+	if wait <= 0 {
+		wait = defaultCooldown
+	}
+
+	if until := kr.now().Add(wait); until.After(key.benchedUntil) {
+		key.benchedUntil = until
+	}
+}
+
+// ReportHeaders records the rate limit headers of a response sent with key.
+// The provider's remaining quota then drives selection until it resets,
+// and a key reported as exhausted is benched until then.
+func (kr *KeyRotator) ReportHeaders(key *ApiKey, h http.Header) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	now := kr.now()
+	for _, l := range []struct {
+		dst        *reportedLimit
+		candidates []limitHeaders
+	}{
+		{&key.reportedRequests, requestLimitHeaders},
+		{&key.reportedTokens, tokenLimitHeaders},
+	} {
+		reported := parseLimit(h, l.candidates, now)
+		if !reported.known {
+			continue
+		}
+		*l.dst = reported
+		if reported.remaining <= 0 && reported.reset.After(key.benchedUntil) {
+			key.benchedUntil = reported.reset
 		}
 	}
+}
+
+// Release returns res without recording a request, for keys that were
+// reserved but never used.
+func (kr *KeyRotator) Release(res *Reservation, reserved int) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
 
-	return nil, fmt.Errorf("no available keys for provider: %s", provider)
+	kr.release(res, reserved)
 }
 
-func (kr *KeyRotator) ReportUsage(key *ApiKey, tokens int) {
+// TokenUsage returns the tokens key has used within the current window.
+func (kr *KeyRotator) TokenUsage(key *ApiKey) int {
 	kr.mu.Lock()
 	defer kr.mu.Unlock()
 
-	key.usageCount++
-	key.lastUsed = time.Now()
+	_, used := key.usage(kr.now())
+	return used
+}
+
+func excluded(key *ApiKey, exclude []*ApiKey) bool {
+	for _, k := range exclude {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (kr *KeyRotator) release(res *Reservation, reserved int) {
+	k := res.ApiKey
+	if k.inflight > 0 {
+		k.inflight--
+	}
+	k.reservedTokens -= reserved
+	if k.reservedTokens < 0 {
+		k.reservedTokens = 0
+	}
+	k.breaker.done(res.keyTrial)
+	kr.providerBreaker(k.Config.Provider).done(res.providerTrial)
+}
+
+// usage returns the requests completed and tokens used within the window
+// ending at now, dropping older entries from the log.
+func (k *ApiKey) usage(now time.Time) (requests, tokens int) {
+	cutoff := now.Add(-rateWindow)
+	i := 0
+	for i < len(k.requestLog) && !k.requestLog[i].at.After(cutoff) {
+		i++
+	}
+	k.requestLog = k.requestLog[i:]
+
+	for _, u := range k.requestLog {
+		tokens += u.tokens
+	}
+	return len(k.requestLog), tokens
+}
+
+func (k *ApiKey) hasReportedCapacity(now time.Time, tokens int) bool {
+	if k.reportedRequests.active(now) && k.reportedRequests.remaining <= 0 {
+		return false
+	}
+	if k.reportedTokens.active(now) && k.reportedTokens.remaining < tokens {
+		return false
+	}
+	return true
+}
+
+func (k *ApiKey) hasTokenCapacity(used, tokens int) bool {
+	if k.Config.MaxTPM <= 0 {
+		return true
+	}
+
+	committed := used + k.reservedTokens
+	// A request estimated above the whole budget can only ever run on an
+	// idle key; refusing it outright would block it forever.
+	if tokens > k.Config.MaxTPM {
+		return committed == 0
+	}
+	return committed+tokens <= k.Config.MaxTPM
 }
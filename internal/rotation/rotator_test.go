@@ -1,6 +1,7 @@
 package rotation
 
 import (
+	"net/http"
 	"testing"
 	"time"
 
@@ -88,7 +89,7 @@ func TestKeyRotator(t *testing.T) {
 				}
 
 				// Report some usage
-				rotator.ReportUsage(key, 100)
+				rotator.Settle(key, 0, 100)
 			}
 		})
 	}
@@ -104,20 +105,22 @@ func TestRateLimiting(t *testing.T) {
 		},
 	}
 
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
 	rotator := NewKeyRotator(configs)
+	rotator.now = clock.now
 
 	// Make initial requests
 	key1, err := rotator.GetKey("openai")
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
-	rotator.ReportUsage(key1, 100)
+	rotator.Settle(key1, 0, 100)
 
 	key2, err := rotator.GetKey("openai")
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
-	rotator.ReportUsage(key2, 100)
+	rotator.Settle(key2, 0, 100)
 
 	// Third request should be rate limited
 	_, err = rotator.GetKey("openai")
@@ -126,7 +129,7 @@ func TestRateLimiting(t *testing.T) {
 	}
 
 	// Wait for rate limit to reset
-	time.Sleep(time.Minute)
+	clock.advance(time.Minute)
 
 	// Should be able to get a key again
 	key3, err := rotator.GetKey("openai")
@@ -137,3 +140,420 @@ func TestRateLimiting(t *testing.T) {
 		t.Fatal("Expected key but got nil after rate limit reset")
 	}
 }
+
+// fakeClock lets tests move the rotator's notion of time forward.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func TestTokenRateLimiting(t *testing.T) {
+	configs := []config.APIKeyConfig{
+		{
+			Key:      "test-key-1",
+			Provider: "openai",
+			MaxRPM:   100,
+			MaxTPM:   1000,
+		},
+	}
+
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	rotator := NewKeyRotator(configs)
+	rotator.now = clock.now
+
+	// Two concurrent requests can't reserve more than the budget
+	key1, err := rotator.Reserve("openai", 600)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := rotator.Reserve("openai", 600); err == nil {
+		t.Fatal("Expected reservation beyond max_tpm to fail")
+	}
+
+	// Settling with the actual usage frees the unused part of the estimate
+	rotator.Settle(key1, 600, 300)
+	if used := rotator.TokenUsage(key1.ApiKey); used != 300 {
+		t.Errorf("Expected 300 tokens used, got %d", used)
+	}
+
+	clock.advance(30 * time.Second)
+	key2, err := rotator.Reserve("openai", 600)
+	if err != nil {
+		t.Fatalf("Unexpected error after settling: %v", err)
+	}
+	rotator.Settle(key2, 600, 600)
+
+	if _, err := rotator.Reserve("openai", 200); err == nil {
+		t.Fatal("Expected reservation to fail with 900 of 1000 tokens used")
+	}
+
+	// The first request leaves the window after a minute
+	clock.advance(31 * time.Second)
+	if used := rotator.TokenUsage(key2.ApiKey); used != 600 {
+		t.Errorf("Expected 600 tokens in window, got %d", used)
+	}
+	key3, err := rotator.Reserve("openai", 200)
+	if err != nil {
+		t.Fatalf("Unexpected error once old usage left the window: %v", err)
+	}
+	rotator.Release(key3, 200)
+
+	// A request larger than the whole budget still runs on an idle key
+	clock.advance(time.Minute)
+	key4, err := rotator.Reserve("openai", 5000)
+	if err != nil {
+		t.Fatalf("Expected oversized request to run on an idle key: %v", err)
+	}
+	rotator.Release(key4, 5000)
+}
+
+func TestTokenLimitRotatesKeys(t *testing.T) {
+	configs := []config.APIKeyConfig{
+		{Key: "test-key-1", Provider: "openai", MaxRPM: 100, MaxTPM: 1000},
+		{Key: "test-key-2", Provider: "openai", MaxRPM: 100, MaxTPM: 1000},
+	}
+	rotator := NewKeyRotator(configs)
+
+	key1, err := rotator.Reserve("openai", 800)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	key2, err := rotator.Reserve("openai", 800)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if key1 == key2 {
+		t.Error("Expected the second reservation to move to another key")
+	}
+	if _, err := rotator.Reserve("openai", 800); err == nil {
+		t.Error("Expected all keys to be exhausted")
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	configs := []config.APIKeyConfig{
+		{
+			Key:      "test-key-1",
+			Provider: "openai",
+			MaxRPM:   2,
+		},
+	}
+
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	rotator := NewKeyRotator(configs)
+	rotator.now = clock.now
+
+	// A key used steadily must recover as old requests leave the window
+	for _, step := range []time.Duration{0, 30 * time.Second} {
+		clock.advance(step)
+		key, err := rotator.GetKey("openai")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		rotator.Settle(key, 0, 0)
+	}
+
+	clock.advance(20 * time.Second)
+	if _, err := rotator.GetKey("openai"); err == nil {
+		t.Error("Expected rate limit error at 50s but got none")
+	}
+
+	// The first request left the window at 60s, the second has not
+	clock.advance(11 * time.Second)
+	key, err := rotator.GetKey("openai")
+	if err != nil {
+		t.Fatalf("Expected key after the first request expired, got %v", err)
+	}
+	rotator.Settle(key, 0, 0)
+
+	if _, err := rotator.GetKey("openai"); err == nil {
+		t.Error("Expected rate limit error with two requests in the window but got none")
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	tests := []struct {
+		name        string
+		cooldownSec int
+		retryAfter  time.Duration
+		want        time.Duration
+	}{
+		{"cooldown_sec", 30, 0, 30 * time.Second},
+		{"retry hint wins", 30, 5 * time.Second, 5 * time.Second},
+		{"default", 0, 0, defaultCooldown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs := []config.APIKeyConfig{
+				{Key: "test-key-1", Provider: "openai", MaxRPM: 100, CooldownSec: tt.cooldownSec},
+				{Key: "test-key-2", Provider: "openai", MaxRPM: 100, CooldownSec: tt.cooldownSec},
+			}
+
+			clock := &fakeClock{t: time.Unix(1700000000, 0)}
+			rotator := NewKeyRotator(configs)
+			rotator.now = clock.now
+
+			key, err := rotator.GetKey("openai")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			rotator.Cooldown(key.ApiKey, tt.retryAfter)
+			rotator.Release(key, 0)
+
+			// The benched key is skipped in favour of the other one
+			next, err := rotator.GetKey("openai")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if next.Config.Key != "test-key-2" {
+				t.Errorf("Expected test-key-2 while test-key-1 is benched, got %s", next.Config.Key)
+			}
+			rotator.Release(next, 0)
+			rotator.Cooldown(next.ApiKey, tt.retryAfter)
+
+			clock.advance(tt.want - time.Millisecond)
+			if _, err := rotator.GetKey("openai"); err == nil {
+				t.Error("Expected no keys while both are benched")
+			}
+
+			clock.advance(time.Millisecond)
+			key, err = rotator.GetKey("openai")
+			if err != nil {
+				t.Fatalf("Expected key after cooldown, got %v", err)
+			}
+			if key.Config.Key != "test-key-1" {
+				t.Errorf("Expected test-key-1 after cooldown, got %s", key.Config.Key)
+			}
+		})
+	}
+}
+
+func TestHasKey(t *testing.T) {
+	configs := []config.APIKeyConfig{
+		{Key: "test-key-1", Provider: "openai", MaxRPM: 100},
+		{Key: "test-key-2", Provider: "openai", MaxRPM: 100},
+	}
+	rotator := NewKeyRotator(configs)
+	rotator.SetStrategy("openai", RoundRobin)
+
+	for i := 0; i < 3; i++ {
+		if !rotator.HasKey("openai") {
+			t.Fatal("Expected openai to have a key")
+		}
+	}
+	if rotator.HasKey("anthropic") {
+		t.Error("Expected anthropic to have no key")
+	}
+
+	// Checking leaves the round robin where it was
+	for _, want := range []string{"test-key-1", "test-key-2"} {
+		key, err := rotator.GetKey("openai")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if key.Config.Key != want {
+			t.Errorf("Expected %s, got %s", want, key.Config.Key)
+		}
+		rotator.Release(key, 0)
+		rotator.HasKey("openai")
+	}
+}
+
+func TestKeyStrategies(t *testing.T) {
+	threeKeys := []config.APIKeyConfig{
+		{Key: "test-key-1", Provider: "openai", MaxRPM: 100, MaxTPM: 10000},
+		{Key: "test-key-2", Provider: "openai", MaxRPM: 100, MaxTPM: 10000},
+		{Key: "test-key-3", Provider: "openai", MaxRPM: 100, MaxTPM: 10000},
+	}
+
+	testCases := []struct {
+		name     string
+		strategy Strategy
+		configs  []config.APIKeyConfig
+		setup    func(kr *KeyRotator)
+		want     []string
+	}{
+		{
+			name:     "round robin",
+			strategy: RoundRobin,
+			configs:  threeKeys,
+			want:     []string{"test-key-1", "test-key-2", "test-key-3", "test-key-1", "test-key-2"},
+		},
+		{
+			name:    "round robin is the default",
+			configs: threeKeys,
+			want:    []string{"test-key-1", "test-key-2", "test-key-3", "test-key-1"},
+		},
+		{
+			name:     "round robin skips benched keys",
+			strategy: RoundRobin,
+			configs:  threeKeys,
+			setup: func(kr *KeyRotator) {
+				kr.Cooldown(kr.keys[1], time.Hour)
+			},
+			want: []string{"test-key-1", "test-key-3", "test-key-1", "test-key-3"},
+		},
+		{
+			name:     "least recently used",
+			strategy: LeastRecentlyUsed,
+			configs:  threeKeys,
+			setup: func(kr *KeyRotator) {
+				// test-key-1 has just been used, test-key-3 before test-key-2
+				kr.keys[0].lastUsed = kr.now().Add(-time.Second)
+				kr.keys[1].lastUsed = kr.now().Add(-2 * time.Second)
+				kr.keys[2].lastUsed = kr.now().Add(-3 * time.Second)
+			},
+			want: []string{"test-key-3", "test-key-2", "test-key-1", "test-key-3"},
+		},
+		{
+			name:     "least loaded",
+			strategy: LeastLoaded,
+			configs: []config.APIKeyConfig{
+				{Key: "test-key-1", Provider: "openai", MaxRPM: 10, MaxTPM: 10000},
+				{Key: "test-key-2", Provider: "openai", MaxRPM: 100, MaxTPM: 10000},
+				{Key: "test-key-3", Provider: "openai", MaxRPM: 100, MaxTPM: 1000},
+			},
+			setup: func(kr *KeyRotator) {
+				// Headroom: test-key-1 50% of RPM, test-key-2 90% of RPM,
+				// test-key-3 20% of TPM
+				for i := 0; i < 5; i++ {
+					kr.ReportUsage(kr.keys[0], 0)
+				}
+				for i := 0; i < 10; i++ {
+					kr.ReportUsage(kr.keys[1], 0)
+				}
+				kr.ReportUsage(kr.keys[2], 800)
+			},
+			want: []string{"test-key-2", "test-key-2", "test-key-2"},
+		},
+		{
+			name:     "weighted",
+			strategy: Weighted,
+			configs: []config.APIKeyConfig{
+				{Key: "test-key-1", Provider: "openai", MaxRPM: 100, MaxTPM: 10000, Weight: 3},
+				{Key: "test-key-2", Provider: "openai", MaxRPM: 100, MaxTPM: 10000},
+			},
+			want: []string{
+				"test-key-1", "test-key-1", "test-key-2", "test-key-1",
+				"test-key-1", "test-key-1", "test-key-2", "test-key-1",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := &fakeClock{t: time.Unix(1700000000, 0)}
+			rotator := NewKeyRotator(tc.configs)
+			rotator.now = clock.now
+			rotator.SetStrategy("openai", tc.strategy)
+			if tc.setup != nil {
+				tc.setup(rotator)
+			}
+
+			for i, want := range tc.want {
+				clock.advance(time.Millisecond)
+				key, err := rotator.GetKey("openai")
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if key.Config.Key != want {
+					t.Errorf("Pick %d: expected %s, got %s", i, want, key.Config.Key)
+				}
+				rotator.Release(key, 0)
+			}
+		})
+	}
+}
+
+func TestReportedQuota(t *testing.T) {
+	configs := []config.APIKeyConfig{
+		{Key: "test-key-1", Provider: "openai", MaxRPM: 100, MaxTPM: 10000},
+		{Key: "test-key-2", Provider: "openai", MaxRPM: 100, MaxTPM: 10000},
+	}
+
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	rotator := NewKeyRotator(configs)
+	rotator.now = clock.now
+
+	key1, key2 := rotator.keys[0], rotator.keys[1]
+
+	// One request left on the first key: it is used once, then skipped
+	// until the reported reset
+	rotator.ReportHeaders(key1, http.Header{
+		"X-Ratelimit-Remaining-Requests": {"1"},
+		"X-Ratelimit-Reset-Requests":     {"10s"},
+	})
+	for i, want := range []*ApiKey{key1, key2, key2} {
+		key, err := rotator.GetKey("openai")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if key.ApiKey != want {
+			t.Errorf("Pick %d: expected %s, got %s", i, want.Config.Key, key.Config.Key)
+		}
+		rotator.Release(key, 0)
+	}
+
+	clock.advance(10 * time.Second)
+	rotator.SetStrategy("openai", LeastRecentlyUsed)
+	key, _ := rotator.GetKey("openai")
+	if key.ApiKey != key1 {
+		t.Errorf("Expected test-key-1 after its quota reset, got %s", key.Config.Key)
+	}
+	rotator.Release(key, 0)
+
+	// Anthropic reports remaining tokens with an absolute reset time
+	rotator.ReportHeaders(key2, http.Header{
+		"Anthropic-Ratelimit-Tokens-Remaining": {"300"},
+		"Anthropic-Ratelimit-Tokens-Reset":     {clock.now().Add(30 * time.Second).UTC().Format(time.RFC3339)},
+	})
+	rotator.ReportHeaders(key1, http.Header{
+		"Anthropic-Ratelimit-Tokens-Remaining": {"0"},
+		"Anthropic-Ratelimit-Tokens-Reset":     {clock.now().Add(30 * time.Second).UTC().Format(time.RFC3339)},
+	})
+	if _, err := rotator.Reserve("openai", 500); err == nil {
+		t.Error("Expected reservation beyond the reported tokens to fail")
+	}
+	key, err := rotator.Reserve("openai", 200)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if key.ApiKey != key2 {
+		t.Errorf("Expected test-key-2 with tokens left, got %s", key.Config.Key)
+	}
+}
+
+func TestLeastLoadedUsesReportedQuota(t *testing.T) {
+	configs := []config.APIKeyConfig{
+		{Key: "test-key-1", Provider: "openai", MaxRPM: 100, MaxTPM: 10000},
+		{Key: "test-key-2", Provider: "openai", MaxRPM: 100, MaxTPM: 10000},
+	}
+
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	rotator := NewKeyRotator(configs)
+	rotator.now = clock.now
+	rotator.SetStrategy("openai", LeastLoaded)
+
+	// Both keys look idle to us, but the provider says the first is shared
+	// with other traffic and mostly spent
+	rotator.ReportHeaders(rotator.keys[0], http.Header{
+		"X-Ratelimit-Limit-Requests":     {"100"},
+		"X-Ratelimit-Remaining-Requests": {"10"},
+	})
+
+	key, err := rotator.GetKey("openai")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if key.Config.Key != "test-key-2" {
+		t.Errorf("Expected test-key-2, got %s", key.Config.Key)
+	}
+}
@@ -0,0 +1,138 @@
+package rotation
+
+import (
+	"strings"
+	"time"
+)
+
+// Strategy decides which of a provider's available keys serves the next
+// request.
+type Strategy string
+
+const (
+	// RoundRobin cycles through the provider's keys in config order,
+	// skipping keys that are out of capacity. It is the default.
+	RoundRobin Strategy = "round_robin"
+	// LeastRecentlyUsed picks the key that was handed out longest ago.
+	LeastRecentlyUsed Strategy = "lru"
+	// LeastLoaded picks the key with the most RPM/TPM headroom left.
+	LeastLoaded Strategy = "least_loaded"
+	// Weighted spreads requests in proportion to each key's weight.
+	Weighted Strategy = "weighted"
+)
+
+// SetStrategy sets how keys for provider are chosen. An empty or unknown
+// strategy selects RoundRobin.
+func (kr *KeyRotator) SetStrategy(provider string, strategy Strategy) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	kr.strategies[provider] = Strategy(strings.ToLower(string(strategy)))
+}
+
+// pick chooses one of the eligible keys of provider, whose full key list in
+// config order is pool.
+func (kr *KeyRotator) pick(provider string, pool, eligible []*ApiKey, now time.Time) *ApiKey {
+	switch kr.strategies[provider] {
+	case LeastRecentlyUsed:
+		return leastRecentlyUsed(eligible)
+	case LeastLoaded:
+		return leastLoaded(eligible, now)
+	case Weighted:
+		return weighted(eligible)
+	default:
+		return kr.roundRobin(provider, pool, eligible)
+	}
+}
+
+func (kr *KeyRotator) roundRobin(provider string, pool, eligible []*ApiKey) *ApiKey {
+	start := kr.cursors[provider] % len(pool)
+	for i := range pool {
+		key := pool[(start+i)%len(pool)]
+		for _, candidate := range eligible {
+			if candidate == key {
+				kr.cursors[provider] = (start + i + 1) % len(pool)
+				return key
+			}
+		}
+	}
+	return eligible[0]
+}
+
+func leastRecentlyUsed(eligible []*ApiKey) *ApiKey {
+	best := eligible[0]
+	for _, key := range eligible[1:] {
+		if key.lastUsed.Before(best.lastUsed) {
+			best = key
+		}
+	}
+	return best
+}
+
+func leastLoaded(eligible []*ApiKey, now time.Time) *ApiKey {
+	best, bestHeadroom := eligible[0], eligible[0].headroom(now)
+	for _, key := range eligible[1:] {
+		if h := key.headroom(now); h > bestHeadroom {
+			best, bestHeadroom = key, h
+		}
+	}
+	return best
+}
+
+// weighted implements smooth weighted round-robin: every key gains its
+// weight on each pick and the chosen key pays back the total, which
+// interleaves keys rather than sending runs of requests to the heaviest.
+func weighted(eligible []*ApiKey) *ApiKey {
+	var best *ApiKey
+	total := 0
+	for _, key := range eligible {
+		w := key.weight()
+		key.currentWeight += w
+		total += w
+		if best == nil || key.currentWeight > best.currentWeight {
+			best = key
+		}
+	}
+	best.currentWeight -= total
+	return best
+}
+
+func (k *ApiKey) weight() int {
+	if k.Config.Weight <= 0 {
+		return 1
+	}
+	return k.Config.Weight
+}
+
+// headroom returns the fraction of the key's tighter limit that is still
+// free within the window ending at now, preferring the provider's reported
+// quota to our own count where it is known.
+func (k *ApiKey) headroom(now time.Time) float64 {
+	requests, used := k.usage(now)
+	free := 1 - float64(requests+k.inflight)/float64(k.Config.MaxRPM)
+	if r, ok := k.reportedRequests.fraction(now); ok {
+		free = r
+	}
+
+	tokens := 1.0
+	if k.Config.MaxTPM > 0 {
+		tokens = 1 - float64(used+k.reservedTokens)/float64(k.Config.MaxTPM)
+	}
+	if t, ok := k.reportedTokens.fraction(now); ok {
+		tokens = t
+	}
+
+	if tokens < free {
+		return tokens
+	}
+	return free
+}
+
+// fraction returns the share of the reported limit still remaining, if the
+// provider reported both while the limit is current.
+func (l reportedLimit) fraction(now time.Time) (float64, bool) {
+	if !l.active(now) || l.limit <= 0 {
+		return 0, false
+	}
+	return float64(l.remaining) / float64(l.limit), true
+}
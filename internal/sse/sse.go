@@ -0,0 +1,97 @@
+// Package sse reads and writes the server-sent events wire format used by
+// LLM provider streaming APIs.
+package sse
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Event is a single server-sent event. Comment-only events (keep-alives such
+// as ": OPENROUTER PROCESSING") are preserved so they can be relayed as-is.
+type Event struct {
+	ID      string
+	Name    string
+	Data    string
+	Comment string
+}
+
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next event in the stream, or io.EOF once the stream ends.
+func (r *Reader) Next() (Event, error) {
+	var ev Event
+	var data []string
+	var comments []string
+	seen := false
+
+	for {
+		line, err := r.r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			if err == io.EOF && seen {
+				ev.Data = strings.Join(data, "\n")
+				ev.Comment = strings.Join(comments, "\n")
+				return ev, nil
+			}
+			return Event{}, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "" {
+			if !seen {
+				continue
+			}
+			ev.Data = strings.Join(data, "\n")
+			ev.Comment = strings.Join(comments, "\n")
+			return ev, nil
+		}
+		seen = true
+
+		if strings.HasPrefix(line, ":") {
+			comments = append(comments, strings.TrimPrefix(strings.TrimPrefix(line, ":"), " "))
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "data":
+			data = append(data, value)
+		case "event":
+			ev.Name = value
+		case "id":
+			ev.ID = value
+		}
+	}
+}
+
+// Write encodes ev onto w, terminated by the blank line that ends an event.
+func Write(w io.Writer, ev Event) error {
+	var b strings.Builder
+	if ev.Comment != "" {
+		for _, line := range strings.Split(ev.Comment, "\n") {
+			b.WriteString(": " + line + "\n")
+		}
+	}
+	if ev.ID != "" {
+		b.WriteString("id: " + ev.ID + "\n")
+	}
+	if ev.Name != "" {
+		b.WriteString("event: " + ev.Name + "\n")
+	}
+	if ev.Data != "" || (ev.Comment == "" && ev.Name != "") {
+		for _, line := range strings.Split(ev.Data, "\n") {
+			b.WriteString("data: " + line + "\n")
+		}
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,52 @@
+package sse
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReaderAndWriter(t *testing.T) {
+	input := ": keep-alive\n\n" +
+		"event: message_start\r\ndata: {\"a\":1}\r\n\r\n" +
+		"data: line one\ndata: line two\n\n" +
+		"data: [DONE]"
+
+	want := []Event{
+		{Comment: "keep-alive"},
+		{Name: "message_start", Data: `{"a":1}`},
+		{Data: "line one\nline two"},
+		{Data: "[DONE]"},
+	}
+
+	reader := NewReader(strings.NewReader(input))
+	var buf bytes.Buffer
+	for i, w := range want {
+		ev, err := reader.Next()
+		if err != nil {
+			t.Fatalf("event %d: unexpected error: %v", i, err)
+		}
+		if ev != w {
+			t.Errorf("event %d: got %+v, want %+v", i, ev, w)
+		}
+		if err := Write(&buf, ev); err != nil {
+			t.Fatalf("event %d: write failed: %v", i, err)
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("Expected io.EOF at end of stream, got %v", err)
+	}
+
+	// Re-reading what was written must yield the same events
+	reader = NewReader(&buf)
+	for i, w := range want {
+		ev, err := reader.Next()
+		if err != nil {
+			t.Fatalf("re-read event %d: unexpected error: %v", i, err)
+		}
+		if ev != w {
+			t.Errorf("re-read event %d: got %+v, want %+v", i, ev, w)
+		}
+	}
+}
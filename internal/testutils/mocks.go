@@ -1,9 +1,18 @@
 package testutils
 
 import (
+	"bufio"
 	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // MockLLMRequest represents a common structure for LLM API requests
@@ -74,26 +83,72 @@ func MockOpenAIServer() *httptest.Server {
 	}))
 }
 
-// MockAnthropicServer returns a test server that mimics Anthropic's API
+// MockAnthropicServer returns a test server that mimics Anthropic's Messages
+// API. Streaming requests are answered with Messages API stream events.
 func MockAnthropicServer() *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Header.Get("X-Api-Key") == "" {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
+		if r.Header.Get("Anthropic-Version") == "" || !strings.HasSuffix(r.URL.Path, "/messages") {
+			http.Error(w, "Expected a Messages API request", http.StatusBadRequest)
+			return
+		}
 
-		var req MockLLMRequest
+		var req struct {
+			Model     string            `json:"model"`
+			MaxTokens int               `json:"max_tokens"`
+			Stream    bool              `json:"stream"`
+			Messages  []json.RawMessage `json:"messages"`
+		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
+		if req.MaxTokens <= 0 || len(req.Messages) == 0 {
+			http.Error(w, "max_tokens and messages are required", http.StatusBadRequest)
+			return
+		}
+
+		text := "Mock response for: " + req.Model
+		if req.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			events := []struct {
+				name string
+				data map[string]interface{}
+			}{
+				{"message_start", map[string]interface{}{"type": "message_start", "message": map[string]interface{}{
+					"id": "mock-message-id", "type": "message", "role": "assistant", "model": req.Model,
+					"content": []interface{}{}, "usage": map[string]interface{}{"input_tokens": 50, "output_tokens": 1},
+				}}},
+				{"content_block_start", map[string]interface{}{"type": "content_block_start", "index": 0,
+					"content_block": map[string]interface{}{"type": "text", "text": ""}}},
+				{"ping", map[string]interface{}{"type": "ping"}},
+				{"content_block_delta", map[string]interface{}{"type": "content_block_delta", "index": 0,
+					"delta": map[string]interface{}{"type": "text_delta", "text": text}}},
+				{"content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": 0}},
+				{"message_delta", map[string]interface{}{"type": "message_delta",
+					"delta": map[string]interface{}{"stop_reason": "end_turn"}, "usage": map[string]interface{}{"output_tokens": 20}}},
+				{"message_stop", map[string]interface{}{"type": "message_stop"}},
+			}
+			for _, ev := range events {
+				data, _ := json.Marshal(ev.data)
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.name, data)
+				w.(http.Flusher).Flush()
+			}
+			return
+		}
 
 		response := map[string]interface{}{
-			"id":          "mock-completion-id",
-			"type":        "completion",
-			"model":       req.Model,
-			"completion":  "Mock response for: " + req.Model,
-			"stop_reason": "stop",
+			"id":    "mock-message-id",
+			"type":  "message",
+			"role":  "assistant",
+			"model": req.Model,
+			"content": []map[string]interface{}{
+				{"type": "text", "text": text},
+			},
+			"stop_reason": "end_turn",
 			"usage": map[string]interface{}{
 				"input_tokens":  50,
 				"output_tokens": 20,
@@ -104,3 +159,609 @@ func MockAnthropicServer() *httptest.Server {
 		json.NewEncoder(w).Encode(response)
 	}))
 }
+
+// MockOpenAIStreamServer returns a test server that answers every request
+// with an OpenAI-style event stream, one chunk per word of the reply. Each
+// chunk is flushed separately so callers can observe incremental delivery,
+// and a usage-only chunk follows when stream_options.include_usage is set.
+func MockOpenAIStreamServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if stream, _ := req["stream"].(bool); !stream {
+			http.Error(w, "Expected a streaming request", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		for _, word := range []string{"Mock", " streamed", " response"} {
+			chunk, _ := json.Marshal(map[string]interface{}{
+				"id":     "mock-completion-id",
+				"object": "chat.completion.chunk",
+				"model":  req["model"],
+				"choices": []map[string]interface{}{
+					{"index": 0, "delta": map[string]interface{}{"content": word}},
+				},
+			})
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+			flusher.Flush()
+		}
+		if options, _ := req["stream_options"].(map[string]interface{}); options["include_usage"] == true {
+			fmt.Fprint(w, `data: {"id":"mock-completion-id","object":"chat.completion.chunk","choices":[],`+
+				`"usage":{"prompt_tokens":50,"completion_tokens":3,"total_tokens":53}}`+"\n\n")
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		flusher.Flush()
+	}))
+}
+
+// MockSlowStreamServer returns a test server that starts an event stream and
+// then keeps it open until the client goes away. The returned channel is
+// closed once the server observes the cancellation.
+func MockSlowStreamServer() (*httptest.Server, <-chan struct{}) {
+	cancelled := make(chan struct{})
+	var once sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"first\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-r.Context().Done():
+			once.Do(func() { close(cancelled) })
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	return server, cancelled
+}
+
+// MockDelayedServer returns a test server that waits for delay, or for the
+// request to be cancelled, before answering with a chat completion.
+func MockDelayedServer(delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices a cancelled request once the body is read
+		io.Copy(io.Discard, r.Body)
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(delay):
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(mockChatCompletion("gpt-4"))
+	}))
+}
+
+// MockGatedServer returns a test server that holds every request until
+// gate is closed, or the request is cancelled, then answers it with a chat
+// completion, or an event stream for streaming requests. A stream's first
+// chunk is sent before waiting. The returned function counts the requests
+// received.
+func MockGatedServer(gate <-chan struct{}) (*httptest.Server, func() int) {
+	var mu sync.Mutex
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+
+		var req map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		stream, _ := req["stream"].(bool)
+		if stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"first\"}}]}\n\n")
+			w.(http.Flusher).Flush()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-gate:
+		}
+
+		if stream {
+			fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\" second\"}}]}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(mockChatCompletion(req["model"].(string)))
+	}))
+	return server, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+// MockSlowKeyServer returns a test server that answers requests made with
+// slowKey only after delay and all others at once, along with a channel
+// that is closed when a slow request is cancelled and a counter of the
+// requests it has received.
+func MockSlowKeyServer(slowKey string, delay time.Duration) (*httptest.Server, <-chan struct{}, *int) {
+	cancelled := make(chan struct{})
+	var once sync.Once
+	var mu sync.Mutex
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+
+		var req MockLLMRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if r.Header.Get("Authorization") == "Bearer "+slowKey {
+			select {
+			case <-r.Context().Done():
+				once.Do(func() { close(cancelled) })
+				return
+			case <-time.After(delay):
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(mockChatCompletion(req.Model))
+	}))
+	return server, cancelled, &count
+}
+
+// MockEchoServer returns a test server that answers with a chat completion
+// carrying an extra "echo" field that holds the exact request body it
+// received, so tests can inspect what the proxy forwarded upstream.
+func MockEchoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		var model string
+		json.Unmarshal(req["model"], &model)
+		response := mockChatCompletion(model)
+		response["echo"] = req
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+}
+
+// MockOpenRouterServer returns a test server that mimics OpenRouter's
+// OpenAI-compatible API. Model names must be in OpenRouter's
+// "vendor/model" form, and the attribution headers received are reflected
+// back as X-Mock-Referer and X-Mock-Title.
+func MockOpenRouterServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req MockLLMRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !strings.Contains(req.Model, "/") {
+			http.Error(w, "Unknown model: "+req.Model, http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Mock-Referer", r.Header.Get("HTTP-Referer"))
+		w.Header().Set("X-Mock-Title", r.Header.Get("X-Title"))
+		json.NewEncoder(w).Encode(mockChatCompletion(req.Model))
+	}))
+}
+
+// MockChutesServer returns a test server that mimics Chutes' OpenAI-compatible
+// API.
+func MockChutesServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req MockLLMRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(mockChatCompletion(req.Model))
+	}))
+}
+
+// MockRateLimitedServer returns a test server that rejects every request
+// with 429 and the given Retry-After header, along with a counter of the
+// requests it has received.
+func MockRateLimitedServer(retryAfter string) (*httptest.Server, *int) {
+	var mu sync.Mutex
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+
+		w.Header().Set("Retry-After", retryAfter)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": map[string]interface{}{
+				"message": "Rate limit exceeded",
+				"type":    "rate_limit_error",
+				"code":    "rate_limit_exceeded",
+			},
+		})
+	}))
+	return server, &count
+}
+
+// MockUnauthorizedServer returns a test server that rejects every request
+// with 401, as a provider does for a revoked key, along with a counter of
+// the requests it has received.
+func MockUnauthorizedServer() (*httptest.Server, *int) {
+	var mu sync.Mutex
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": map[string]interface{}{
+				"message": "Incorrect API key provided",
+				"type":    "invalid_request_error",
+				"code":    "invalid_api_key",
+			},
+		})
+	}))
+	return server, &count
+}
+
+// MockFlakyServer returns a test server that fails its first failures
+// requests with status and answers the rest with a chat completion, along
+// with the API keys it saw, in order.
+func MockFlakyServer(failures, status int) (*httptest.Server, *[]string) {
+	var mu sync.Mutex
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		failing := len(keys) <= failures
+		mu.Unlock()
+
+		var req MockLLMRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if failing {
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]interface{}{
+					"message": fmt.Sprintf("Mock failure with status %d", status),
+					"type":    "server_error",
+				},
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(mockChatCompletion(req.Model))
+	}))
+	return server, &keys
+}
+
+// MockModelFailureServer returns a test server that fails requests for
+// the failing model with status and answers the rest with a chat
+// completion, along with the models it was asked for, in order.
+func MockModelFailureServer(failing string, status int) (*httptest.Server, *[]string) {
+	var mu sync.Mutex
+	var models []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req MockLLMRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		models = append(models, req.Model)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if req.Model == failing {
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]interface{}{
+					"message": fmt.Sprintf("Mock failure with status %d", status),
+					"type":    "server_error",
+				},
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(mockChatCompletion(req.Model))
+	}))
+	return server, &models
+}
+
+// MockQuotaServer returns a test server that answers every request with a
+// chat completion and the given rate limit headers, along with a counter of
+// the requests it has received.
+func MockQuotaServer(headers map[string]string) (*httptest.Server, *int) {
+	var mu sync.Mutex
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+
+		var req MockLLMRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		for name, value := range headers {
+			w.Header().Set(name, value)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(mockChatCompletion(req.Model))
+	}))
+	return server, &count
+}
+
+func mockChatCompletion(model string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":     "mock-completion-id",
+		"object": "chat.completion",
+		"model":  model,
+		"choices": []map[string]interface{}{
+			{
+				"message": map[string]interface{}{
+					"role":    "assistant",
+					"content": "Mock response for: " + model,
+				},
+				"finish_reason": "stop",
+			},
+		},
+		"usage": map[string]interface{}{
+			"prompt_tokens":     50,
+			"completion_tokens": 20,
+			"total_tokens":      70,
+		},
+	}
+}
+
+// MockRedis is a stand-in for a Redis server speaking just enough of the
+// protocol for the cache: PING, AUTH, SELECT, GET, SET with PX or EX, DEL
+// and SCAN. Every database shares one keyspace.
+type MockRedis struct {
+	Addr     string
+	Password string // Required by AUTH when set
+
+	listener net.Listener
+	mu       sync.Mutex
+	data     map[string]mockRedisEntry
+	conns    map[net.Conn]bool
+}
+
+type mockRedisEntry struct {
+	value     string
+	expiresAt time.Time
+}
+
+// MockRedisServer starts a MockRedis on a free local port.
+func MockRedisServer(password string) *MockRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("mock redis: failed to listen: %v", err))
+	}
+	m := &MockRedis{
+		Addr:     l.Addr().String(),
+		Password: password,
+		listener: l,
+		data:     make(map[string]mockRedisEntry),
+		conns:    make(map[net.Conn]bool),
+	}
+	go m.serve()
+	return m
+}
+
+// Close stops the server and drops its connections.
+func (m *MockRedis) Close() {
+	m.listener.Close()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for conn := range m.conns {
+		conn.Close()
+	}
+}
+
+// Keys returns the keys currently stored.
+func (m *MockRedis) Keys() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for k := range m.data {
+		if m.live(k) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Expire makes every stored key expire immediately.
+func (m *MockRedis) Expire() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, e := range m.data {
+		e.expiresAt = time.Now().Add(-time.Second)
+		m.data[k] = e
+	}
+}
+
+func (m *MockRedis) live(key string) bool {
+	e, ok := m.data[key]
+	if ok && !e.expiresAt.IsZero() && time.Now().After(e.expiresAt) {
+		delete(m.data, key)
+		return false
+	}
+	return ok
+}
+
+func (m *MockRedis) serve() {
+	for {
+		conn, err := m.listener.Accept()
+		if err != nil {
+			return
+		}
+		m.mu.Lock()
+		m.conns[conn] = true
+		m.mu.Unlock()
+		go m.handle(conn)
+	}
+}
+
+func (m *MockRedis) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		m.mu.Lock()
+		delete(m.conns, conn)
+		m.mu.Unlock()
+	}()
+
+	r := bufio.NewReader(conn)
+	authed := m.Password == ""
+	for {
+		args, err := readMockRedisCommand(r)
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		if !authed && cmd != "AUTH" && cmd != "PING" {
+			io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+		if cmd == "AUTH" {
+			authed = len(args) == 2 && args[1] == m.Password
+			if !authed {
+				io.WriteString(conn, "-WRONGPASS invalid password\r\n")
+				continue
+			}
+		}
+		io.WriteString(conn, m.exec(cmd, args[1:]))
+	}
+}
+
+func (m *MockRedis) exec(cmd string, args []string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch cmd {
+	case "PING":
+		return "+PONG\r\n"
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		if len(args) != 1 || !m.live(args[0]) {
+			return "$-1\r\n"
+		}
+		return mockRedisBulk(m.data[args[0]].value)
+	case "SET":
+		if len(args) < 2 {
+			return "-ERR wrong number of arguments\r\n"
+		}
+		entry := mockRedisEntry{value: args[1]}
+		if len(args) == 4 {
+			n, _ := strconv.Atoi(args[3])
+			unit := time.Millisecond
+			if strings.ToUpper(args[2]) == "EX" {
+				unit = time.Second
+			}
+			entry.expiresAt = time.Now().Add(time.Duration(n) * unit)
+		}
+		m.data[args[0]] = entry
+		return "+OK\r\n"
+	case "DEL":
+		deleted := 0
+		for _, k := range args {
+			if m.live(k) {
+				delete(m.data, k)
+				deleted++
+			}
+		}
+		return ":" + strconv.Itoa(deleted) + "\r\n"
+	case "SCAN":
+		// Every key is returned in one page
+		pattern := "*"
+		for i := 1; i+1 < len(args); i += 2 {
+			if strings.ToUpper(args[i]) == "MATCH" {
+				pattern = args[i+1]
+			}
+		}
+		var keys []string
+		for k := range m.data {
+			if ok, _ := path.Match(pattern, k); ok && m.live(k) {
+				keys = append(keys, mockRedisBulk(k))
+			}
+		}
+		return "*2\r\n" + mockRedisBulk("0") + "*" + strconv.Itoa(len(keys)) + "\r\n" + strings.Join(keys, "")
+	}
+	return "-ERR unknown command '" + cmd + "'\r\n"
+}
+
+func mockRedisBulk(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+func readMockRedisCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("malformed command: %q", line)
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}